@@ -0,0 +1,74 @@
+package gziphandler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// debugLevel returns the compression level reported in
+// the X-Compression header and logged by Logger.
+func debugLevel(level int) int {
+	// compress/flate uses level 6 by default.
+	if level == DefaultCompression {
+		return 6
+	}
+
+	return level
+}
+
+// setDebugHeaders sets the X-Compression and Server-Timing
+// headers, see DebugHeaders. If trailer is true, they are
+// set as trailers.
+func setDebugHeaders(h http.Header, info *responseInfo, trailer bool) {
+	var prefix string
+	if trailer {
+		prefix = http.TrailerPrefix
+	}
+
+	b := make([]byte, 0, 64)
+	b = append(b, info.encoding...)
+
+	if info.encoding == "gzip" {
+		b = append(b, ";level="...)
+		b = strconv.AppendInt(b, int64(debugLevel(info.level)), 10)
+	}
+
+	if info.reason != "" {
+		b = append(b, ";reason="...)
+		b = append(b, info.reason...)
+	}
+
+	if info.transcoded != "" {
+		b = append(b, ";transcoded="...)
+		b = append(b, info.transcoded...)
+	}
+
+	b = append(b, ";in="...)
+	b = strconv.AppendInt(b, info.in, 10)
+	b = append(b, ";out="...)
+	b = strconv.AppendInt(b, info.out, 10)
+
+	h.Set(prefix+"X-Compression", string(b))
+
+	if info.encoding != "gzip" {
+		return
+	}
+
+	b = append(b[:0], "gzip;dur="...)
+	b = strconv.AppendFloat(b, float64(info.encodeTime)/float64(time.Millisecond), 'f', 3, 64)
+
+	// Server-Timing may have more than one value, so we
+	// don't replace any the handler set.
+	h.Add(prefix+"Server-Timing", string(b))
+}
+
+// contentEncoding returns the content coding of a
+// response that isn't being compressed.
+func contentEncoding(h http.Header) string {
+	if ce := h.Get("Content-Encoding"); ce != "" {
+		return ce
+	}
+
+	return "identity"
+}
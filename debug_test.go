@@ -0,0 +1,140 @@
+package gziphandler
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func isDebugRequest(r *http.Request) bool {
+	return r.Header.Get("X-Debug") != ""
+}
+
+func TestDebugHeaders(t *testing.T) {
+	gzipLen := strconv.Itoa(len(gzipStrLevel(testBody, DefaultCompression)))
+	testLen := strconv.Itoa(len(testBody))
+
+	for _, tc := range []struct {
+		name           string
+		acceptEncoding string
+		debug          bool
+		encoding       string
+		body           []byte
+		opts           []Option
+
+		expectHeader  string
+		expectTrailer string
+		expectTiming  bool
+	}{
+		{"disabled", "gzip", false, "", []byte(testBody), nil, "", "", false},
+		{"gzip", "gzip", true, "", []byte(testBody), nil,
+			"", "gzip;level=6;in=" + testLen + ";out=" + gzipLen, true},
+		{"min-size", "gzip", true, "", []byte("test"), nil,
+			"identity;reason=min-size;in=4;out=4", "", false},
+		{"not-accepted", "", true, "", []byte(testBody), nil,
+			"", "identity;reason=not-accepted;in=" + testLen + ";out=" + testLen, false},
+		{"not-accepted-small", "", true, "", []byte("test"), nil,
+			"", "identity;reason=not-accepted;in=4;out=4", false},
+		{"encoded", "gzip", true, "br", []byte("test"), nil,
+			"", "br;reason=encoded;in=4;out=4", false},
+		{"transcoded", "", true, "deflate", zlibStr(testBody), []Option{Transcode(nil)},
+			"", "identity;reason=not-accepted;transcoded=deflate;in=" + testLen + ";out=" + testLen, false},
+		{"level", "gzip", true, "", []byte(testBody), []Option{CompressionLevel(BestSpeed)},
+			"", "gzip;level=1;in=" + testLen + ";out=" + strconv.Itoa(len(gzipStrLevel(testBody, BestSpeed))), true},
+	} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			if tc.encoding != "" {
+				w.Header().Set("Content-Encoding", tc.encoding)
+			}
+
+			w.Write(tc.body)
+		}), append(tc.opts, DebugHeaders(isDebugRequest))...)
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+		if tc.debug {
+			req.Header.Set("X-Debug", "1")
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, tc.expectHeader, res.Header.Get("X-Compression"), tc.name)
+		assert.Equal(t, tc.expectTrailer, res.Trailer.Get("X-Compression"), tc.name)
+
+		if tc.expectTiming {
+			assert.Regexp(t, `^gzip;dur=\d+\.\d{3}$`, res.Trailer.Get("Server-Timing"), tc.name)
+		} else {
+			assert.Equal(t, "", res.Header.Get("Server-Timing"), tc.name)
+			assert.Equal(t, "", res.Trailer.Get("Server-Timing"), tc.name)
+		}
+	}
+}
+
+func TestDebugHeadersServerTiming(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server-Timing", "db;dur=53")
+		w.Header().Set(http.TrailerPrefix+"Server-Timing", "app;dur=47.2")
+		io.WriteString(w, testBody)
+	}), DebugHeaders(isDebugRequest))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("X-Debug", "1")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	res := resp.Result()
+	assert.Equal(t, []string{"db;dur=53"}, res.Header["Server-Timing"])
+
+	trailer := res.Trailer["Server-Timing"]
+	require.Len(t, trailer, 2)
+	assert.Equal(t, "app;dur=47.2", trailer[0])
+	assert.Regexp(t, `^gzip;dur=`, trailer[1])
+}
+
+func TestDebugHeadersServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, testBody)
+	}), DebugHeaders(isDebugRequest)))
+	defer srv.Close()
+
+	for _, acceptEncoding := range []string{"gzip", "identity"} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err, "Unexpected error making http request")
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		req.Header.Set("X-Debug", "1")
+
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err, "Unexpected error making http request")
+
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err, "Unexpected error reading response body")
+		res.Body.Close()
+
+		if acceptEncoding == "gzip" {
+			assert.Equal(t, "gzip;level=6;in="+strconv.Itoa(len(testBody))+";out="+strconv.Itoa(len(body)),
+				res.Trailer.Get("X-Compression"))
+			assert.Regexp(t, `^gzip;dur=`, res.Trailer.Get("Server-Timing"))
+		} else {
+			assert.Equal(t, "identity;reason=not-accepted;in="+strconv.Itoa(len(testBody))+";out="+strconv.Itoa(len(body)),
+				res.Trailer.Get("X-Compression"))
+		}
+	}
+}
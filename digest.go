@@ -0,0 +1,214 @@
+package gziphandler
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// digestAlgorithms are the digest algorithms supported by
+// Digests. See RFC 9530, section 5.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// digester hashes a response for the Content-Digest and
+// Repr-Digest fields, see Digests.
+type digester struct {
+	// The algorithms to send in each field.
+	content, repr []string
+
+	// The hashes of the content, in the same order as
+	// algs.
+	algs   []string
+	hashes []hash.Hash
+}
+
+// newDigester returns a digester for the digest fields
+// requested by h, or nil if no fields should be sent.
+func newDigester(h http.Header, algs []string) *digester {
+	content, wantContent := wantDigest(h, "Want-Content-Digest", algs)
+	repr, wantRepr := wantDigest(h, "Want-Repr-Digest", algs)
+
+	// Send both fields with every algorithm if the client
+	// didn't ask for either.
+	if !wantContent && !wantRepr {
+		content, repr = algs, algs
+	}
+
+	if len(content) == 0 && len(repr) == 0 {
+		return nil
+	}
+
+	d := &digester{content: content, repr: repr}
+	for _, alg := range algs {
+		if containsString(content, alg) || containsString(repr, alg) {
+			d.algs = append(d.algs, alg)
+			d.hashes = append(d.hashes, digestAlgorithms[alg]())
+		}
+	}
+
+	return d
+}
+
+// wantDigest parses the Want-Content-Digest or
+// Want-Repr-Digest header named key and returns the
+// algorithm from algs the client most prefers. The second
+// result is false if the header isn't present.
+//
+// The header is a structured field dictionary of
+// algorithms and preferences from 0 to 10, where 0 means
+// not acceptable. Members that can't be parsed are
+// ignored. See RFC 9530, section 4.
+func wantDigest(h http.Header, key string, algs []string) ([]string, bool) {
+	lines, ok := h[key]
+	if !ok {
+		return nil, false
+	}
+
+	var (
+		best     string
+		bestPref int64
+	)
+	for _, line := range lines {
+		for _, member := range strings.Split(line, ",") {
+			alg, pref := member, ""
+			if i := strings.IndexByte(member, '='); i >= 0 {
+				alg, pref = member[:i], member[i+1:]
+			}
+
+			alg = strings.TrimSpace(alg)
+			if !containsString(algs, alg) {
+				continue
+			}
+
+			// Ignore any parameters.
+			if i := strings.IndexByte(pref, ';'); i >= 0 {
+				pref = pref[:i]
+			}
+
+			p, err := strconv.ParseInt(strings.TrimSpace(pref), 10, 64)
+			if err != nil || p < 0 || p > 10 {
+				continue
+			}
+
+			// Prefer the first of algs for equal
+			// preferences.
+			if p > bestPref || (p == bestPref && p > 0 &&
+				indexString(algs, alg) < indexString(algs, best)) {
+				best, bestPref = alg, p
+			}
+		}
+	}
+
+	if best == "" {
+		return nil, true
+	}
+
+	return []string{best}, true
+}
+
+// start is called before the header is written with the
+// header and status code of the response. It returns false
+// if no digests should be sent.
+func (d *digester) start(h http.Header, code int) bool {
+	if !bodyAllowedForStatus(code) {
+		return false
+	}
+
+	if _, ok := h["Content-Digest"]; ok {
+		d.content = nil
+	}
+
+	// The representation data of a partial response
+	// isn't known.
+	if _, ok := h["Repr-Digest"]; ok || code == http.StatusPartialContent {
+		d.repr = nil
+	}
+
+	return len(d.content) != 0 || len(d.repr) != 0
+}
+
+// declare declares the digest fields as trailers.
+func (d *digester) declare(h http.Header) {
+	if len(d.content) != 0 {
+		h.Add("Trailer", "Content-Digest")
+	}
+
+	if len(d.repr) != 0 {
+		h.Add("Trailer", "Repr-Digest")
+	}
+}
+
+func (d *digester) Write(b []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(b)
+	}
+
+	return len(b), nil
+}
+
+func (d *digester) WriteString(s string) (int, error) {
+	for _, h := range d.hashes {
+		io.WriteString(h, s)
+	}
+
+	return len(s), nil
+}
+
+// set sets the digest fields. If trailer is true, they
+// are set as trailers.
+func (d *digester) set(h http.Header, trailer bool) {
+	var prefix string
+	if trailer {
+		prefix = http.TrailerPrefix
+	}
+
+	if len(d.content) != 0 {
+		h.Set(prefix+"Content-Digest", d.value(d.content))
+	}
+
+	if len(d.repr) != 0 {
+		h.Set(prefix+"Repr-Digest", d.value(d.repr))
+	}
+}
+
+// value returns a structured field dictionary of the
+// digests for algs.
+func (d *digester) value(algs []string) string {
+	var b []byte
+	for i, alg := range algs {
+		if i != 0 {
+			b = append(b, ", "...)
+		}
+
+		sum := d.hashes[indexString(d.algs, alg)].Sum(nil)
+
+		b = append(b, alg...)
+		b = append(b, "=:"...)
+		b = append(b, base64.StdEncoding.EncodeToString(sum)...)
+		b = append(b, ':')
+	}
+
+	return string(b)
+}
+
+func containsString(s []string, v string) bool {
+	return indexString(s, v) >= 0
+}
+
+func indexString(s []string, v string) int {
+	for i, vv := range s {
+		if vv == v {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,340 @@
+package gziphandler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func sha512Digest(b []byte) string {
+	sum := sha512.Sum512(b)
+	return "sha-512=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func TestDigests(t *testing.T) {
+	gzipBody := gzipStrLevel(testBody, DefaultCompression)
+	testDigest := sha256Digest([]byte(testBody))
+
+	for _, tc := range []struct {
+		name           string
+		acceptEncoding string
+		header         http.Header
+		body           string
+
+		expectBody    string
+		expectTrailer bool
+		expectContent string
+		expectRepr    string
+	}{
+		{"gzip", "gzip", nil, testBody,
+			string(gzipBody), true, sha256Digest(gzipBody), sha256Digest(gzipBody)},
+		{"identity", "", nil, testBody,
+			testBody, true, testDigest, testDigest},
+		{"buffered", "gzip", nil, "test",
+			"test", false, sha256Digest([]byte("test")), sha256Digest([]byte("test"))},
+		{"want-content", "gzip", http.Header{"Want-Content-Digest": {"sha-512=3, sha-256=10"}}, testBody,
+			string(gzipBody), true, sha256Digest(gzipBody), ""},
+		{"want-repr", "gzip", http.Header{"Want-Repr-Digest": {"sha-512=3, sha-256=10"}}, testBody,
+			string(gzipBody), true, "", sha256Digest(gzipBody)},
+		{"want-unsupported", "gzip", http.Header{"Want-Content-Digest": {"md5=10"}}, testBody,
+			string(gzipBody), false, "", ""},
+		{"want-none", "gzip", http.Header{"Want-Content-Digest": {"sha-256=0"}}, testBody,
+			string(gzipBody), false, "", ""},
+		{"empty", "gzip", nil, "",
+			"", false, sha256Digest(nil), sha256Digest(nil)},
+	} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, tc.body)
+		}), Digests([]string{"sha-256"}))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		for k, v := range tc.header {
+			req.Header[k] = v
+		}
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, tc.expectBody, resp.Body.String(), tc.name)
+
+		fields := res.Header
+		if tc.expectTrailer {
+			fields = res.Trailer
+		} else {
+			assert.Empty(t, res.Trailer, tc.name)
+		}
+
+		assert.Equal(t, tc.expectContent, fields.Get("Content-Digest"), tc.name)
+		assert.Equal(t, tc.expectRepr, fields.Get("Repr-Digest"), tc.name)
+	}
+}
+
+func TestDigestsAlgorithms(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}), Digests([]string{"sha-512", "sha-256"}))
+
+	for _, tc := range []struct {
+		want   string
+		expect string
+	}{
+		{"", sha512Digest([]byte(testBody)) + ", " + sha256Digest([]byte(testBody))},
+		{"sha-256=1, sha-512=1", sha512Digest([]byte(testBody))},
+		{"sha-256=2, sha-512=1", sha256Digest([]byte(testBody))},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		if tc.want != "" {
+			req.Header.Set("Want-Content-Digest", tc.want)
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tc.expect, resp.Result().Trailer.Get("Content-Digest"), tc.want)
+	}
+}
+
+func TestDigestsNoBody(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		code   int
+	}{
+		{http.MethodHead, http.StatusOK},
+		{http.MethodGet, http.StatusNoContent},
+		{http.MethodGet, http.StatusNotModified},
+	} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.code)
+		}), Digests([]string{"sha-256"}))
+
+		req := httptest.NewRequest(tc.method, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, "", res.Header.Get("Content-Digest"), "%s %d", tc.method, tc.code)
+		assert.Equal(t, "", res.Header.Get("Trailer"), "%s %d", tc.method, tc.code)
+		assert.Empty(t, res.Trailer, "%s %d", tc.method, tc.code)
+	}
+}
+
+func TestDigestsPartialContent(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Range", "bytes 0-9/"+strconv.Itoa(len(testBody)))
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, testBody[:10])
+	}), Digests([]string{"sha-256"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Range", "bytes=0-9")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	res := resp.Result()
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, []string{"Content-Digest"}, res.Header["Trailer"])
+	assert.Equal(t, sha256Digest([]byte(testBody[:10])), res.Trailer.Get("Content-Digest"))
+	assert.Equal(t, "", res.Trailer.Get("Repr-Digest"))
+}
+
+func TestDigestsHandlerDigest(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Repr-Digest", "sha-256=:abc=:")
+		io.WriteString(w, testBody)
+	}), Digests([]string{"sha-256"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	res := resp.Result()
+	assert.Equal(t, "sha-256=:abc=:", res.Header.Get("Repr-Digest"))
+	assert.Equal(t, []string{"Content-Digest"}, res.Header["Trailer"])
+	assert.Equal(t, sha256Digest([]byte(testBody)), res.Trailer.Get("Content-Digest"))
+	assert.Equal(t, "", res.Trailer.Get("Repr-Digest"))
+}
+
+func TestDigestsReadFrom(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+
+		// Hide any WriterTo method so that io.Copy uses
+		// ReadFrom.
+		io.Copy(w, struct{ io.Reader }{strings.NewReader(testBody)})
+	}), Digests([]string{"sha-256"}))
+
+	for _, acceptEncoding := range []string{"", "gzip"} {
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, acceptEncoding, res.Header.Get("Content-Encoding"))
+		assert.Equal(t, sha256Digest(resp.Body.Bytes()), res.Trailer.Get("Content-Digest"), acceptEncoding)
+		assert.Equal(t, sha256Digest(resp.Body.Bytes()), res.Trailer.Get("Repr-Digest"), acceptEncoding)
+
+		if acceptEncoding == "" {
+			assert.Equal(t, testBody, resp.Body.String())
+		}
+	}
+}
+
+func TestDigestsTranscode(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "deflate")
+		w.Write(zlibStr(testBody))
+	}), Transcode(nil), Digests([]string{"sha-256"}))
+
+	for _, acceptEncoding := range []string{"", "gzip"} {
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		// The digests are of the transcoded response.
+		res := resp.Result()
+		assert.Equal(t, acceptEncoding, res.Header.Get("Content-Encoding"))
+		assert.Equal(t, sha256Digest(resp.Body.Bytes()), res.Trailer.Get("Content-Digest"), acceptEncoding)
+		assert.Equal(t, sha256Digest(resp.Body.Bytes()), res.Trailer.Get("Repr-Digest"), acceptEncoding)
+
+		if acceptEncoding == "" {
+			assert.Equal(t, testBody, resp.Body.String())
+		}
+	}
+}
+
+func TestDigestsWriteBuffer(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for s := testBody; s != ""; {
+			n := 10
+			if len(s) < n {
+				n = len(s)
+			}
+
+			io.WriteString(w, s[:n])
+			s = s[n:]
+		}
+	}), WriteBufferSize(64), Digests([]string{"sha-256"}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	res := resp.Result()
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, sha256Digest(resp.Body.Bytes()), res.Trailer.Get("Content-Digest"))
+	assert.Equal(t, sha256Digest(resp.Body.Bytes()), res.Trailer.Get("Repr-Digest"))
+}
+
+func TestDigestsServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, testBody)
+	}), Digests([]string{"sha-256"})))
+	defer srv.Close()
+
+	for _, acceptEncoding := range []string{"gzip", "identity"} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err, "Unexpected error making http request")
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err, "Unexpected error making http request")
+
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err, "Unexpected error reading response body")
+		res.Body.Close()
+
+		assert.Equal(t, sha256Digest(body), res.Trailer.Get("Content-Digest"), acceptEncoding)
+		assert.Equal(t, sha256Digest(body), res.Trailer.Get("Repr-Digest"), acceptEncoding)
+
+		if acceptEncoding == "gzip" {
+			assert.False(t, bytes.Equal([]byte(testBody), body), "response was not compressed")
+		}
+	}
+}
+
+func TestWantDigest(t *testing.T) {
+	algs := []string{"sha-256", "sha-512"}
+
+	for _, tc := range []struct {
+		header   []string
+		expect   []string
+		expectOK bool
+	}{
+		{nil, nil, false},
+		{[]string{""}, nil, true},
+		{[]string{"sha-256=1"}, []string{"sha-256"}, true},
+		{[]string{"sha-512=1"}, []string{"sha-512"}, true},
+		{[]string{"sha-256=1, sha-512=2"}, []string{"sha-512"}, true},
+		{[]string{"sha-512=2, sha-256=2"}, []string{"sha-256"}, true},
+		{[]string{"sha-256=1", "sha-512=2"}, []string{"sha-512"}, true},
+		{[]string{"sha-256=0"}, nil, true},
+		{[]string{"md5=10, sha-256=1"}, []string{"sha-256"}, true},
+		{[]string{"sha-256"}, nil, true},
+		{[]string{"sha-256=11"}, nil, true},
+		{[]string{"sha-256=x, sha-512=3"}, []string{"sha-512"}, true},
+		{[]string{" sha-512 = 5 ;p=1"}, []string{"sha-512"}, true},
+	} {
+		h := make(http.Header)
+		if tc.header != nil {
+			h["Want-Content-Digest"] = tc.header
+		}
+
+		algs, ok := wantDigest(h, "Want-Content-Digest", algs)
+		assert.Equal(t, tc.expect, algs, "%q", tc.header)
+		assert.Equal(t, tc.expectOK, ok, "%q", tc.header)
+	}
+}
+
+func TestDigestsPanicsForInvalid(t *testing.T) {
+	assert.PanicsWithValue(t, "gziphandler: no digest algorithms", func() {
+		Digests(nil)
+	}, "Digests did not panic with no algorithms")
+	assert.PanicsWithValue(t, `gziphandler: unsupported digest algorithm "md5"`, func() {
+		Digests([]string{"md5"})
+	}, "Digests did not panic with unsupported algorithm")
+	assert.PanicsWithValue(t, `gziphandler: unsupported digest algorithm "SHA-256"`, func() {
+		Digests([]string{"SHA-256"})
+	}, "Digests did not panic with upper case algorithm")
+}
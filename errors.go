@@ -0,0 +1,102 @@
+package gziphandler
+
+import "time"
+
+// WriteError is returned when writing to the underlying
+// http.ResponseWriter fails, usually because the client
+// has disconnected.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return "gziphandler: write error: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// EncoderError is returned when the gzip encoder fails for
+// a reason other than a WriteError.
+type EncoderError struct {
+	Err error
+}
+
+func (e *EncoderError) Error() string {
+	return "gziphandler: encoder error: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *EncoderError) Unwrap() error {
+	return e.Err
+}
+
+// DecoderError is returned when a response being
+// transcoded can't be decoded, see Transcode.
+type DecoderError struct {
+	Err error
+}
+
+func (e *DecoderError) Error() string {
+	return "gziphandler: decoder error: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *DecoderError) Unwrap() error {
+	return e.Err
+}
+
+// rawWriter writes to the underlying http.ResponseWriter of
+// a responseWriter, wrapping any error in a *WriteError.
+type rawWriter responseWriter
+
+func (w *rawWriter) Write(b []byte) (int, error) {
+	rw := (*responseWriter)(w)
+
+	start := rw.now()
+	n, err := w.ResponseWriter.Write(b)
+
+	if w.info != nil {
+		w.info.out += int64(n)
+
+		// Time spent writing isn't time spent encoding.
+		w.info.encodeTime -= time.Since(start)
+	}
+
+	if w.digest != nil {
+		w.digest.Write(b[:n])
+	}
+
+	if err != nil {
+		err = &WriteError{Err: err}
+	}
+
+	return n, err
+}
+
+// setErr records err if it is the first error for the
+// response. It returns err.
+func (w *responseWriter) setErr(err error) error {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return err
+}
+
+// gzipError records and returns an error from the gzip
+// writer, wrapping it in an *EncoderError if it isn't a
+// *WriteError.
+func (w *responseWriter) gzipError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*WriteError); !ok {
+		err = &EncoderError{Err: err}
+	}
+
+	return w.setErr(err)
+}
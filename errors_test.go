@@ -0,0 +1,201 @@
+package gziphandler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTestWrite = errors.New("write failed")
+
+// errorResponseWriter is an http.ResponseWriter whose Write
+// and WriteString methods always fail.
+type errorResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *errorResponseWriter) Write(b []byte) (int, error) {
+	return 0, errTestWrite
+}
+
+func (w *errorResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func TestErrorHandlerWriteError(t *testing.T) {
+	for _, minSize := range []int{0, len(testBody) + 1} {
+		var (
+			errs     []error
+			writeErr error
+		)
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, writeErr = io.WriteString(w, testBody)
+			io.WriteString(w, testBody)
+		}), MinSize(minSize), ErrorHandler(func(r *http.Request, err error) {
+			errs = append(errs, err)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		resp := &errorResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+		handler.ServeHTTP(resp, req)
+
+		require.Len(t, errs, 1, "minSize %d", minSize)
+
+		var we *WriteError
+		require.True(t, errors.As(errs[0], &we), "expected *WriteError, got %#v", errs[0])
+		assert.Equal(t, errTestWrite, we.Err, "minSize %d", minSize)
+		assert.True(t, errors.Is(errs[0], errTestWrite), "minSize %d", minSize)
+
+		if minSize == 0 {
+			assert.Equal(t, errs[0], writeErr, "error was not returned to handler")
+		}
+	}
+}
+
+func TestErrorHandlerPassThrough(t *testing.T) {
+	var errs []error
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, testBody)
+	}), ErrorHandler(func(r *http.Request, err error) {
+		errs = append(errs, err)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := &errorResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(resp, req)
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, &WriteError{Err: errTestWrite}, errs[0])
+}
+
+// errorReaderFromResponseWriter is an errorResponseWriter
+// whose ReadFrom method always fails.
+type errorReaderFromResponseWriter struct {
+	errorResponseWriter
+}
+
+func (w *errorReaderFromResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return 0, errTestWrite
+}
+
+func TestErrorHandlerReadFrom(t *testing.T) {
+	var (
+		errs    []error
+		copyErr error
+	)
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, copyErr = w.(io.ReaderFrom).ReadFrom(strings.NewReader(testBody))
+	}), ErrorHandler(func(r *http.Request, err error) {
+		errs = append(errs, err)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := &errorReaderFromResponseWriter{errorResponseWriter{httptest.NewRecorder()}}
+	handler.ServeHTTP(resp, req)
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, &WriteError{Err: errTestWrite}, errs[0])
+	assert.Equal(t, errs[0], copyErr, "error was not returned to handler")
+}
+
+func TestErrorHandlerReadFromSourceError(t *testing.T) {
+	errRead := errors.New("read failed")
+
+	for _, compress := range []bool{false, true} {
+		var (
+			errs    []error
+			readErr error
+		)
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !compress {
+				w.Header().Set("Content-Type", "image/png")
+			}
+
+			_, readErr = w.(io.ReaderFrom).ReadFrom(io.MultiReader(
+				strings.NewReader(testBody), errorReader{errRead}))
+		}), ErrorHandler(func(r *http.Request, err error) {
+			errs = append(errs, err)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		rec := httptest.NewRecorder()
+		resp := struct {
+			http.ResponseWriter
+			io.ReaderFrom
+		}{
+			rec,
+			readerFromFunc(func(r io.Reader) (int64, error) {
+				return io.Copy(rec, r)
+			}),
+		}
+		handler.ServeHTTP(resp, req)
+
+		require.Len(t, errs, 1, "compress: %t", compress)
+		assert.Equal(t, errRead, errs[0], "compress: %t", compress)
+		assert.Equal(t, errRead, readErr, "compress: %t", compress)
+	}
+}
+
+func TestErrorHandlerDecoderError(t *testing.T) {
+	var errs []error
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		io.WriteString(w, testBody)
+	}), Transcode(nil), ErrorHandler(func(r *http.Request, err error) {
+		errs = append(errs, err)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	require.Len(t, errs, 1)
+
+	var de *DecoderError
+	assert.True(t, errors.As(errs[0], &de), "expected *DecoderError, got %#v", errs[0])
+}
+
+func TestErrorHandlerNoError(t *testing.T) {
+	var called bool
+	handler := newTestHandler(testBody, ErrorHandler(func(r *http.Request, err error) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.False(t, called, "ErrorHandler called without an error")
+}
+
+func TestGzipError(t *testing.T) {
+	w := &responseWriter{}
+
+	assert.NoError(t, w.gzipError(nil))
+
+	errEncoder := errors.New("encoder failed")
+	assert.Equal(t, &EncoderError{Err: errEncoder}, w.gzipError(errEncoder))
+
+	we := &WriteError{Err: errTestWrite}
+	assert.Equal(t, we, w.gzipError(we))
+
+	// Only the first error is recorded.
+	assert.Equal(t, &EncoderError{Err: errEncoder}, w.err)
+}
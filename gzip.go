@@ -7,13 +7,23 @@ import (
 	"bufio"
 	"compress/gzip"
 	"io"
+	"math"
 	"net"
 	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/tmthrgd/httputils"
 )
 
+//go:generate go run wrapper_gen.go
+
 // DefaultMinSize is the default minimum size for which we enable gzip compression.
 //
 // This is provided for two main reasons:
@@ -49,6 +59,10 @@ import (
 // default.
 const defaultMinSize = 150
 
+// defaultParallelBlockSize is the default block size for
+// ParallelCompression.
+const defaultParallelBlockSize = 1 << 20
+
 var bufferPool = &sync.Pool{
 	New: func() interface{} {
 		buf := make([]byte, 0, defaultMinSize)
@@ -56,6 +70,13 @@ var bufferPool = &sync.Pool{
 	},
 }
 
+var copyBufferPool = &sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
 var gzipWriterPools [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
 
 func gzipWriterPool(level int) *sync.Pool {
@@ -96,6 +117,7 @@ type responseWriter struct {
 	http.ResponseWriter
 
 	h *handler
+	r *http.Request
 
 	gw *gzip.Writer
 
@@ -105,6 +127,58 @@ type responseWriter struct {
 
 	// Saves the WriteHeader value.
 	code int
+
+	// Whether the client doesn't accept gzip and the
+	// response is only wrapped to be transcoded.
+	noGzip bool
+
+	// Decodes the response in pass through mode if it has
+	// a content coding the client doesn't accept.
+	tc *transcoder
+
+	// The first error that occurred while writing the
+	// response, see setErr.
+	err error
+
+	// Records how the response was written, if needed.
+	info *responseInfo
+
+	// Whether to send the debug trailers once the
+	// response is closed, see DebugHeaders.
+	debug bool
+
+	// Hashes the response for the digest trailers, see
+	// Digests.
+	digest *digester
+
+	// Compresses the response in parallel once it is
+	// large enough, see ParallelCompression.
+	pw *parallelWriter
+
+	// Coalesces small writes to the gzip writer, see
+	// WriteBufferSize.
+	wb *[]byte
+
+	// Whether the response has a streaming Content-Type
+	// and should be flushed after each event.
+	streaming bool
+
+	// The line ending state of the event stream, see
+	// scanEvents.
+	eol byte
+
+	// Whether data has been written to gw since it was
+	// last flushed.
+	dirty bool
+
+	// The timer that periodically flushes gw, see
+	// FlushInterval. mu guards gw while it is running.
+	timer *time.Timer
+	mu    sync.Mutex
+
+	// Whether the handler has hijacked the connection,
+	// after which nothing more may be written.
+	hijacked bool
 }
 
 // WriteHeader just saves the response code until close or
@@ -123,10 +197,10 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 	// GZIP responseWriter is initialized. Use the GZIP
 	// responseWriter.
 	case w.gw != nil:
-		return w.gw.Write(b)
+		return w.writeGzip(b)
 	// We're operating in pass through mode.
 	case w.buf == nil:
-		return w.ResponseWriter.Write(b)
+		return w.writePassThrough(b)
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -138,10 +212,10 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 			return 0, err
 		}
 
-		return w.ResponseWriter.Write(b)
+		return w.writePassThrough(b)
 	}
 
-	if w.shouldBuffer(b) {
+	if w.shouldBuffer(len(b)) && !w.isStreaming() {
 		// Save the write into a buffer for later.
 		// This buffer will be flushed in either
 		// startGzip or startPassThrough.
@@ -153,19 +227,347 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 
 	// Now that we've called inferContentType, we have
 	// a Content-Type header.
-	if w.shouldPassThrough() {
+	if w.shouldPassThrough() || w.isIncompressible(b) {
 		if err := w.startPassThrough(); err != nil {
 			return 0, err
 		}
 
-		return w.ResponseWriter.Write(b)
+		return w.writePassThrough(b)
 	}
 
 	if err := w.startGzip(); err != nil {
 		return 0, err
 	}
 
-	return w.gw.Write(b)
+	return w.writeGzip(b)
+}
+
+// writeGzip writes b to the gzip writer, flushing it if
+// b completes an event of a streaming response.
+func (w *responseWriter) writeGzip(b []byte) (int, error) {
+	w.lock()
+	defer w.unlock()
+
+	n, err := w.encode(b)
+	w.count(n)
+	err = w.gzipError(err)
+	w.dirty = true
+
+	if err != nil || !w.streaming || !w.scanEvents(b[:n]) {
+		return n, err
+	}
+
+	// The underlying http.ResponseWriter may not support
+	// flushing, in which case there is nothing more we
+	// can do.
+	if err := w.flushGzip(); err != nil && err != http.ErrNotSupported {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// flushGzip flushes the gzip writer and then the
+// underlying http.ResponseWriter.
+func (w *responseWriter) flushGzip() error {
+	if err := w.flushWriteBuffer(); err != nil {
+		return w.gzipError(err)
+	}
+
+	start := w.now()
+	err := w.encoder().Flush()
+	w.addEncodeTime(start)
+
+	if err != nil {
+		return w.gzipError(err)
+	}
+
+	w.dirty = false
+	return flush(w.ResponseWriter)
+}
+
+// startTimer starts the timer that periodically flushes
+// the gzip writer.
+func (w *responseWriter) startTimer() {
+	// The timer may fire before time.AfterFunc returns, so
+	// we hold mu until w.timer has been set.
+	w.mu.Lock()
+	w.timer = time.AfterFunc(w.h.flushInterval, w.flushTimer)
+	w.mu.Unlock()
+}
+
+// flushTimer is called by the timer every flushInterval.
+// It flushes the gzip writer if it has been written to
+// since it was last flushed.
+func (w *responseWriter) flushTimer() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// The response has already been closed.
+	if w.gw == nil {
+		return
+	}
+
+	// Any error here will be returned from the next call
+	// to Write.
+	if w.dirty {
+		w.flushGzip()
+	}
+
+	w.timer.Reset(w.h.flushInterval)
+}
+
+// lock locks mu if the timer is running.
+func (w *responseWriter) lock() {
+	if w.timer != nil {
+		w.mu.Lock()
+	}
+}
+
+// unlock unlocks mu if the timer is running.
+func (w *responseWriter) unlock() {
+	if w.timer != nil {
+		w.mu.Unlock()
+	}
+}
+
+// scanEvents reports whether b contains the end of a
+// server-sent event, i.e. a blank line. Lines may end in
+// CRLF, LF or CR. The state of the previous line ending is
+// carried across calls in w.eol.
+func (w *responseWriter) scanEvents(b []byte) bool {
+	const (
+		midLine = iota
+		afterLF
+		afterCR
+	)
+
+	var found bool
+	for _, c := range b {
+		switch {
+		case c == '\r':
+			found = found || w.eol != midLine
+			w.eol = afterCR
+		case c == '\n' && w.eol == afterCR:
+			// The LF of a CRLF line ending.
+			w.eol = afterLF
+		case c == '\n':
+			found = found || w.eol != midLine
+			w.eol = afterLF
+		default:
+			w.eol = midLine
+		}
+	}
+
+	return found
+}
+
+// isStreaming reports whether the Content-Type header
+// matches any of the streaming content types.
+func (w *responseWriter) isStreaming() bool {
+	if len(w.h.streamingTypes) == 0 {
+		return false
+	}
+
+	ct := w.Header().Get("Content-Type")
+	return ct != "" && httputils.MIMETypeMatches(ct, w.h.streamingTypes)
+}
+
+// WriteString is like Write, but accepts a string. It
+// avoids converting s to a []byte, and the allocation
+// that entails, except when the decision whether to
+// compress the response is being made. This makes
+// responseWriter an io.StringWriter.
+func (w *responseWriter) WriteString(s string) (int, error) {
+	switch {
+	case w.buf != nil && w.gw != nil:
+		panic("gziphandler: both buf and gw are non nil in call to WriteString")
+	// GZIP responseWriter is initialized. Use the GZIP
+	// responseWriter.
+	case w.gw != nil:
+		return w.writeStringGzip(s)
+	// We're operating in pass through mode.
+	case w.buf == nil:
+		return w.writeStringPassThrough(s)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	// This may succeed if the Content-Type header was
+	// explicitly set.
+	if w.shouldPassThrough() {
+		if err := w.startPassThrough(); err != nil {
+			return 0, err
+		}
+
+		return w.writeStringPassThrough(s)
+	}
+
+	if w.shouldBuffer(len(s)) && !w.isStreaming() {
+		// Save the write into a buffer for later.
+		// This buffer will be flushed in either
+		// startGzip or startPassThrough.
+		*w.buf = append(*w.buf, s...)
+		return len(s), nil
+	}
+
+	return w.Write([]byte(s))
+}
+
+// writeStringGzip writes s to the gzip writer, copying it
+// through a pooled buffer as *gzip.Writer is not an
+// io.StringWriter.
+func (w *responseWriter) writeStringGzip(s string) (n int, err error) {
+	// A string that fits in the write buffer can be copied
+	// straight into it. Streaming responses must be
+	// scanned for the end of each event, which writeGzip
+	// does.
+	if w.wb != nil && !w.streaming {
+		w.lock()
+		ok := w.err == nil && len(*w.wb)+len(s) <= cap(*w.wb)
+		if ok {
+			*w.wb = append(*w.wb, s...)
+			w.count(len(s))
+			w.dirty = true
+		}
+		w.unlock()
+
+		if ok {
+			return len(s), nil
+		}
+	}
+
+	bp := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bp)
+
+	for len(s) != 0 {
+		nc := copy(*bp, s)
+		s = s[nc:]
+
+		nw, err := w.writeGzip((*bp)[:nc])
+		n += nw
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// ReadFrom reads data from r until EOF or error and writes
+// it to the response. When compressing, the data is read
+// into a pooled buffer before being written to the gzip
+// writer. In pass through mode, it defers to the
+// underlying http.ResponseWriter if it is an
+// io.ReaderFrom, which allows the sendfile fast path to be
+// used for uncompressed responses. This makes
+// responseWriter an io.ReaderFrom.
+//
+// Errors reading from r are returned and recorded as is,
+// while errors writing the response are a *WriteError. As
+// the kernel reads files sent with sendfile, any error
+// sending a file is treated as a write error.
+func (w *responseWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if w.buf != nil && w.gw == nil {
+		w.WriteHeader(http.StatusOK)
+
+		// This may succeed if the Content-Type header
+		// was explicitly set.
+		if w.shouldPassThrough() {
+			if err := w.startPassThrough(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	bp := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bp)
+
+	buf := *bp
+	for {
+		// The response must be written through us to
+		// be hashed.
+		if w.gw == nil && w.buf == nil && w.digest == nil {
+			// We're operating in pass through mode.
+			if rf, ok := w.passThrough().(io.ReaderFrom); ok {
+				src := newSourceReader(r)
+				nn, err := rf.ReadFrom(src)
+				if w.tc == nil {
+					if err != nil && !isSourceError(src) {
+						err = &WriteError{Err: err}
+					}
+
+					if w.info != nil {
+						w.info.in += nn
+						w.info.out += nn
+					}
+				}
+
+				return n + nn, w.setErr(err)
+			}
+		}
+
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			nw, werr := w.Write(buf[:nr])
+			n += int64(nw)
+
+			switch {
+			case werr != nil:
+				return n, werr
+			case nw != nr:
+				return n, io.ErrShortWrite
+			}
+		}
+
+		switch {
+		case rerr == io.EOF:
+			return n, nil
+		case rerr != nil:
+			return n, w.setErr(rerr)
+		}
+	}
+}
+
+// sourceReader records any error reading from the
+// io.Reader passed to ReadFrom, so that it can be told
+// apart from an error writing the response.
+type sourceReader struct {
+	io.Reader
+	err error
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// newSourceReader wraps r in a sourceReader, unless it is
+// a file. net/http only uses sendfile for an *os.File,
+// optionally within an *io.LimitedReader.
+func newSourceReader(r io.Reader) io.Reader {
+	f := r
+	if lr, ok := r.(*io.LimitedReader); ok {
+		f = lr.R
+	}
+
+	if _, ok := f.(*os.File); ok {
+		return r
+	}
+
+	return &sourceReader{Reader: r}
+}
+
+// isSourceError reports whether reading from src, as
+// returned by newSourceReader, failed.
+func isSourceError(src io.Reader) bool {
+	sr, ok := src.(*sourceReader)
+	return ok && sr.err != nil
 }
 
 // startGzip initialize any GZIP specific informations.
@@ -181,17 +583,46 @@ func (w *responseWriter) startGzip() (err error) {
 	// See: https://github.com/golang/go/issues/14975.
 	h.Del("Content-Length")
 
+	w.h.addVary(h)
+
+	w.declareTrailers()
+
 	// Write the header to gzip response.
 	w.ResponseWriter.WriteHeader(w.code)
 
 	// Bytes written during ServeHTTP are redirected to
 	// this gzip writer before being written to the
 	// underlying response.
-	w.gw = gzipWriterGet(w.ResponseWriter, w.h.level)
+	w.gw = gzipWriterGet((*rawWriter)(w), w.h.level)
+
+	if w.h.gzipHeader != nil {
+		w.h.gzipHeader(w.r, h, &w.gw.Header)
+		sanitizeGzipHeader(&w.gw.Header)
+	}
+
+	if w.h.parallel != nil {
+		w.pw = newParallelWriter(w.gw, (*rawWriter)(w), w.h.level, w.h.parallel)
+	}
+
+	if w.h.writeBufferSize > 0 {
+		w.wb = writeBufferGet(w.h.writeBufferSize)
+	}
+
+	if w.info != nil {
+		w.info.encoding = "gzip"
+		w.info.level = w.h.level
+		w.info.reason = ""
+	}
+
+	w.streaming = w.isStreaming()
+
+	if w.h.flushInterval > 0 {
+		w.startTimer()
+	}
 
 	if buf := *w.buf; len(buf) != 0 {
 		// Flush the buffer into the gzip response.
-		_, err = w.gw.Write(buf)
+		_, err = w.writeGzip(buf)
 	}
 
 	w.releaseBuffer()
@@ -199,16 +630,109 @@ func (w *responseWriter) startGzip() (err error) {
 }
 
 func (w *responseWriter) startPassThrough() (err error) {
+	if decode := w.transcodeDecoder(); decode != nil {
+		return w.startTranscode(decode)
+	}
+
+	w.h.addVary(w.Header())
+
+	if w.info != nil {
+		w.info.encoding = contentEncoding(w.Header())
+	}
+
+	w.declareTrailers()
+
 	w.ResponseWriter.WriteHeader(w.code)
 
 	if buf := *w.buf; len(buf) != 0 {
-		_, err = w.ResponseWriter.Write(buf)
+		_, err = w.writePassThrough(buf)
 	}
 
 	w.releaseBuffer()
 	return err
 }
 
+// declareTrailers declares the trailers that are set once
+// the response is closed. It must be called before the
+// header is written.
+//
+// net/http doesn't require trailers set with
+// http.TrailerPrefix to be declared, but it will set
+// Content-Length, and so not send any trailers, if the
+// handler returns before the response is large enough to
+// be chunked.
+func (w *responseWriter) declareTrailers() {
+	if w.digest != nil && !w.digest.start(w.Header(), w.code) {
+		w.digest = nil
+	}
+
+	if w.debug {
+		w.Header().Add("Trailer", "X-Compression")
+	}
+
+	if w.digest != nil {
+		w.digest.declare(w.Header())
+	}
+}
+
+// passThrough returns the http.ResponseWriter that is
+// written to in pass through mode.
+func (w *responseWriter) passThrough() http.ResponseWriter {
+	if w.tc != nil {
+		return w.tc
+	}
+
+	return w.ResponseWriter
+}
+
+// writePassThrough writes b in pass through mode.
+func (w *responseWriter) writePassThrough(b []byte) (int, error) {
+	if w.tc != nil {
+		n, err := w.tc.Write(b)
+		return n, w.setErr(err)
+	}
+
+	n, err := (*rawWriter)(w).Write(b)
+	w.count(n)
+	return n, w.setErr(err)
+}
+
+// writeStringPassThrough writes s in pass through mode.
+func (w *responseWriter) writeStringPassThrough(s string) (int, error) {
+	n, err := io.WriteString(w.passThrough(), s)
+	if w.tc == nil {
+		if err != nil {
+			err = &WriteError{Err: err}
+		}
+
+		if w.info != nil {
+			w.info.in += int64(n)
+			w.info.out += int64(n)
+		}
+
+		if w.digest != nil {
+			w.digest.WriteString(s[:n])
+		}
+	}
+
+	return n, w.setErr(err)
+}
+
+// startBuffered decides whether to compress the response
+// based on what has been written so far, and then calls
+// either startGzip or startPassThrough.
+func (w *responseWriter) startBuffered() error {
+	w.inferContentType(nil)
+
+	w.WriteHeader(http.StatusOK)
+
+	if w.shouldPassThrough() || w.isIncompressible(nil) {
+		return w.startPassThrough()
+	}
+
+	return w.startGzip()
+}
+
 func (w *responseWriter) releaseBuffer() {
 	if w.buf == nil {
 		panic("gziphandler: w.buf is nil in call to emptyBuffer")
@@ -219,12 +743,20 @@ func (w *responseWriter) releaseBuffer() {
 	w.buf = nil
 }
 
-func (w *responseWriter) shouldBuffer(b []byte) bool {
+func (w *responseWriter) shouldBuffer(n int) bool {
 	// If the all writes to date are bigger than the
 	// minSize, we no longer need to buffer and we can
 	// decide whether to enable compression or whether
 	// to operate in pass through mode.
-	return len(*w.buf)+len(b) < w.h.minSize
+	minSize := w.h.minSize
+
+	// MinSaving needs enough of the body to make a
+	// meaningful estimate.
+	if w.h.minSaving > 0 && minSize < minEntropySampleLen {
+		minSize = minEntropySampleLen
+	}
+
+	return len(*w.buf)+n < minSize
 }
 
 func (w *responseWriter) inferContentType(b []byte) {
@@ -235,6 +767,11 @@ func (w *responseWriter) inferContentType(b []byte) {
 		return
 	}
 
+	// As with net/http, we don't sniff an encoded body.
+	if h.Get("Content-Encoding") != "" {
+		return
+	}
+
 	if buf := *w.buf; len(buf) != 0 {
 		const sniffLen = 512
 		if len(buf) >= sniffLen {
@@ -254,21 +791,80 @@ func (w *responseWriter) inferContentType(b []byte) {
 	h.Set("Content-Type", http.DetectContentType(b))
 }
 
-func (w *responseWriter) shouldPassThrough() bool {
-	if w.Header().Get("Content-Encoding") != "" {
-		return true
+// isIncompressible reports whether the estimated saving
+// from compressing the start of the body is below the
+// configured minimum.
+func (w *responseWriter) isIncompressible(b []byte) bool {
+	if w.h.minSaving > 0 && estimateSaving(*w.buf, b) < w.h.minSaving {
+		return w.skip(reasonMinSaving)
 	}
 
-	return !w.handleContentType()
+	return false
 }
 
-func (w *responseWriter) handleContentType() bool {
-	// If contentTypes is empty, accept any content
-	// type.
-	if len(w.h.contentTypes) == 0 {
-		return true
+// entropySampleLen is the maximum number of bytes
+// considered by estimateSaving.
+const entropySampleLen = 4096
+
+// minEntropySampleLen is the minimum number of bytes
+// estimateSaving needs for a meaningful estimate. The
+// order-0 entropy of n < 256 bytes is at most log2(n) bits
+// per byte, so smaller samples of random data would
+// otherwise appear compressible.
+const minEntropySampleLen = 1024
+
+// estimateSaving estimates the fraction by which the
+// concatenation of a and b could be reduced in size. It
+// uses the order-0 entropy of the bytes, so it does not
+// account for repeated strings, but is cheap enough to
+// reliably detect random, encrypted or already
+// compressed data. If fewer than minEntropySampleLen bytes
+// are available, it returns 1.
+func estimateSaving(a, b []byte) float64 {
+	var counts [256]int
+
+	n := 0
+	for _, p := range [2][]byte{a, b} {
+		if len(p) > entropySampleLen-n {
+			p = p[:entropySampleLen-n]
+		}
+
+		for _, c := range p {
+			counts[c]++
+		}
+
+		n += len(p)
+	}
+
+	if n < minEntropySampleLen {
+		return 1
+	}
+
+	var entropy float64
+	for _, c := range counts {
+		if c != 0 {
+			p := float64(c) / float64(n)
+			entropy -= p * math.Log2(p)
+		}
+	}
+
+	return 1 - entropy/8
+}
+
+func (w *responseWriter) shouldPassThrough() bool {
+	switch {
+	case w.noGzip:
+		return w.skip(reasonNotAccepted)
+	case w.Header().Get("Content-Encoding") != "":
+		return w.skip(reasonEncoded)
+	case !w.handleContentType():
+		return w.skip(reasonContentType)
+	default:
+		return false
 	}
+}
 
+func (w *responseWriter) handleContentType() bool {
 	// If the Content-Type header is not set, return
 	// as we haven't called inferContentType yet.
 	ct, ok := w.Header()["Content-Type"]
@@ -276,6 +872,17 @@ func (w *responseWriter) handleContentType() bool {
 		return true
 	}
 
+	if len(ct) != 0 && len(w.h.excludeTypes) != 0 &&
+		httputils.MIMETypeMatches(ct[0], w.h.excludeTypes) {
+		return false
+	}
+
+	// If contentTypes is empty, accept any content
+	// type.
+	if len(w.h.contentTypes) == 0 {
+		return true
+	}
+
 	if len(ct) == 0 {
 		return false
 	}
@@ -286,9 +893,46 @@ func (w *responseWriter) handleContentType() bool {
 // Close will close the gzip.Writer and will put it back in
 // the gzipWriterPool.
 func (w *responseWriter) Close() error {
+	if w.hijacked {
+		w.releaseHijacked()
+		return nil
+	}
+
+	err := w.close()
+
+	if w.debug {
+		setDebugHeaders(w.Header(), w.info, true)
+	}
+
+	if w.digest != nil {
+		w.digest.set(w.Header(), true)
+	}
+
+	// net/http only sends trailers if the response is
+	// chunked, which it won't be if the handler returns
+	// before the response is large enough, unless they
+	// were declared. Flushing before we return makes sure
+	// that undeclared trailers are sent.
+	if _, ok := w.Header()["Trailer"]; !ok && hasTrailerPrefix(w.Header()) {
+		flush(w.ResponseWriter)
+	}
+
+	return err
+}
+
+func (w *responseWriter) close() error {
 	switch {
 	case w.buf != nil && w.gw != nil:
 		panic("gziphandler: both buf and gw are non nil in call to Close")
+	// MinSaving buffered the response beyond MinSize, so
+	// decide whether to compress it as Write would have.
+	case w.buf != nil && w.h.minSaving > 0 && len(*w.buf) != 0 && len(*w.buf) >= w.h.minSize:
+		err := w.startBuffered()
+		if cerr := w.close(); err == nil {
+			err = cerr
+		}
+
+		return err
 	// Buffer not nil means the regular response must
 	// be returned.
 	case w.buf != nil:
@@ -297,6 +941,9 @@ func (w *responseWriter) Close() error {
 	// to close it.
 	case w.gw != nil:
 		return w.closeGzipped()
+	// The response is being transcoded.
+	case w.tc != nil:
+		return w.setErr(w.tc.Close())
 	// Both buf and gw nil means we are operating in
 	// pass through mode.
 	default:
@@ -304,44 +951,296 @@ func (w *responseWriter) Close() error {
 	}
 }
 
-func (w *responseWriter) closeGzipped() error {
-	err := w.gw.Close()
-
-	gzipWriterPut(w.gw, w.h.level)
-	w.gw = nil
-
-	return err
-}
-
-func (w *responseWriter) closeNonGzipped() error {
-	w.inferContentType(nil)
-
-	w.WriteHeader(http.StatusOK)
+// hijack hijacks the underlying connection. It is called
+// by the Hijack method of the http.Hijacker wrappers.
+func (w *responseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
 
-	return w.startPassThrough()
+	return conn, rw, err
 }
 
-// Flush flushes the underlying *gzip.Writer and then the
-// underlying http.ResponseWriter if it is an http.Flusher.
-// This makes responseWriter an http.Flusher.
-func (w *responseWriter) Flush() {
-	if w.gw == nil && w.buf != nil {
-		// Fix for NYTimes/gziphandler#58:
-		//  Only flush once startGzip or
-		//  startPassThrough has been called.
-		//
-		// Flush is thus a no-op until the written
-		// body exceeds minSize, or we've decided
-		// not to compress.
-		return
+// releaseHijacked releases the resources held by the
+// responseWriter without writing anything, as the
+// connection has been hijacked.
+func (w *responseWriter) releaseHijacked() {
+	if w.buf != nil {
+		w.releaseBuffer()
 	}
 
 	if w.gw != nil {
-		w.gw.Flush()
-	}
+		w.lock()
+		defer w.unlock()
 
-	if fw, ok := w.ResponseWriter.(http.Flusher); ok {
-		fw.Flush()
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+
+		if w.pw != nil {
+			w.pw.release()
+		}
+
+		gzipWriterPut(w.gw, w.h.level)
+		w.gw, w.pw = nil, nil
+		w.releaseWriteBuffer()
+	}
+
+	if w.tc != nil {
+		w.tc.abort(http.ErrHijacked)
+	}
+}
+
+func (w *responseWriter) closeGzipped() error {
+	w.lock()
+	defer w.unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	err := w.flushWriteBuffer()
+
+	start := w.now()
+	if cerr := w.encoder().Close(); err == nil {
+		err = cerr
+	}
+	w.addEncodeTime(start)
+
+	err = w.gzipError(err)
+
+	gzipWriterPut(w.gw, w.h.level)
+	w.gw, w.pw = nil, nil
+	w.releaseWriteBuffer()
+
+	return err
+}
+
+func (w *responseWriter) closeNonGzipped() error {
+	w.inferContentType(nil)
+
+	w.WriteHeader(http.StatusOK)
+
+	promoteTrailers(w.Header())
+
+	// The entire body has been buffered, so we know its
+	// length. Setting Content-Length here means small
+	// uncompressed responses are never chunked.
+	if w.shouldSetContentLength() {
+		w.Header().Set("Content-Length", strconv.Itoa(len(*w.buf)))
+	}
+
+	// Record why the response wasn't compressed.
+	if w.info != nil && !w.shouldPassThrough() {
+		w.skip(reasonMinSize)
+	}
+
+	// We know the entire response, so the debug and
+	// digest headers can be sent as headers rather than
+	// trailers. A transcoded response is written by
+	// another responseWriter.
+	if w.transcodeDecoder() == nil {
+		w.setBufferedHeaders()
+	}
+
+	return w.startPassThrough()
+}
+
+// setBufferedHeaders sets the debug and digest headers for
+// a response that is entirely buffered.
+func (w *responseWriter) setBufferedHeaders() {
+	if w.debug {
+		n := int64(len(*w.buf))
+
+		info := *w.info
+		info.encoding = contentEncoding(w.Header())
+		info.in, info.out = n, n
+		setDebugHeaders(w.Header(), &info, false)
+
+		w.debug = false
+	}
+
+	if w.digest != nil {
+		if w.digest.start(w.Header(), w.code) {
+			w.digest.Write(*w.buf)
+			w.digest.set(w.Header(), false)
+		}
+
+		w.digest = nil
+	}
+}
+
+func (w *responseWriter) shouldSetContentLength() bool {
+	h := w.Header()
+
+	if _, ok := h["Content-Length"]; ok {
+		return false
+	}
+
+	if _, ok := h["Transfer-Encoding"]; ok {
+		return false
+	}
+
+	// Trailers can only be sent with a chunked response.
+	if hasTrailers(h) {
+		return false
+	}
+
+	// An empty body is left for the server to handle as
+	// it may be a response to a HEAD request.
+	if len(*w.buf) == 0 {
+		return false
+	}
+
+	return bodyAllowedForStatus(w.code)
+}
+
+// hasTrailers reports whether the handler has declared any
+// trailers or set any with http.TrailerPrefix.
+func hasTrailers(h http.Header) bool {
+	if _, ok := h["Trailer"]; ok {
+		return true
+	}
+
+	return hasTrailerPrefix(h)
+}
+
+// hasTrailerPrefix reports whether any trailers have been
+// set with http.TrailerPrefix.
+func hasTrailerPrefix(h http.Header) bool {
+	for k := range h {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// promoteTrailers moves the values of any declared
+// trailers in h to keys with http.TrailerPrefix.
+//
+// The handler will usually set trailers once it has
+// written the response, which will be before the header
+// is written if the response was buffered. net/http would
+// then send them as both headers and trailers.
+func promoteTrailers(h http.Header) {
+	for _, line := range h["Trailer"] {
+		for _, k := range strings.Split(line, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+
+			if vv, ok := h[k]; ok {
+				delete(h, k)
+				h[http.TrailerPrefix+k] = vv
+			}
+		}
+	}
+}
+
+// bodyAllowedForStatus reports whether a given response
+// status code permits a body. See RFC 7230, section 3.3.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
+	}
+
+	return true
+}
+
+// Flush flushes the underlying *gzip.Writer and then the
+// underlying http.ResponseWriter if it is an http.Flusher.
+// This makes responseWriter an http.Flusher.
+func (w *responseWriter) Flush() {
+	w.FlushError()
+}
+
+// FlushError is like Flush but returns any error that
+// occurred. It is used by http.ResponseController.
+//
+// The underlying http.ResponseWriter is flushed in the
+// same manner as http.ResponseController: with FlushError
+// if available, else with Flush if it is an http.Flusher,
+// else by unwrapping it. If it cannot be flushed,
+// http.ErrNotSupported is returned.
+func (w *responseWriter) FlushError() error {
+	if w.gw == nil && w.buf != nil {
+		if !w.shouldDecideOnFlush() {
+			// Fix for NYTimes/gziphandler#58:
+			//  Only flush once startGzip or
+			//  startPassThrough has been called.
+			//
+			// Flush is thus a no-op until the written
+			// body exceeds minSize, or we've decided
+			// not to compress.
+			return nil
+		}
+
+		if err := w.startBuffered(); err != nil {
+			return err
+		}
+	}
+
+	if w.gw == nil {
+		return flush(w.passThrough())
+	}
+
+	w.lock()
+	defer w.unlock()
+
+	return w.flushGzip()
+}
+
+// shouldDecideOnFlush reports whether a call to Flush
+// before minSize is reached should decide whether to
+// compress the response, rather than being a no-op.
+func (w *responseWriter) shouldDecideOnFlush() bool {
+	switch {
+	// Streaming responses must be sent promptly.
+	case w.isStreaming():
+		return true
+	// We aren't going to compress the response.
+	case w.noGzip:
+		return true
+	case w.h.bufferedFlush != DecideOnFlush:
+		return false
+	}
+
+	// We can't decide whether to compress the response
+	// without knowing the Content-Type.
+	w.inferContentType(nil)
+
+	_, ok := w.Header()["Content-Type"]
+	return ok
+}
+
+// Unwrap returns the underlying http.ResponseWriter. It is
+// used by http.ResponseController to access methods, like
+// SetWriteDeadline, that responseWriter doesn't implement.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush flushes rw in the same manner as the Flush method
+// of http.ResponseController.
+func flush(rw http.ResponseWriter) error {
+	for {
+		switch t := rw.(type) {
+		case interface{ FlushError() error }:
+			return t.FlushError()
+		case http.Flusher:
+			t.Flush()
+			return nil
+		case interface{ Unwrap() http.ResponseWriter }:
+			rw = t.Unwrap()
+		default:
+			return http.ErrNotSupported
+		}
 	}
 }
 
@@ -361,51 +1260,111 @@ func (h *handler) shouldGzip(r *http.Request) bool {
 		}
 	}
 
+	switch h.matchPath(r.URL.Path) {
+	case SkipGzip:
+		return false
+	case ForceGzip:
+		return true
+	}
+
+	if h.matchUserAgent(r.UserAgent()) {
+		return false
+	}
+
 	match := httputils.Negotiate(r.Header, "Accept-Encoding", "gzip")
 	return match == "gzip"
 }
 
-func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Vary", "Accept-Encoding")
+// matchPath returns the ShouldGzipType of the first path
+// rule to match p, or NegotiateGzip if none match.
+func (h *handler) matchPath(p string) ShouldGzipType {
+	for _, rule := range h.pathRules {
+		if rule.match(p) {
+			return rule.typ
+		}
+	}
+
+	return NegotiateGzip
+}
+
+// matchUserAgent reports whether ua matches any of the
+// excluded User-Agent patterns.
+func (h *handler) matchUserAgent(ua string) bool {
+	for _, re := range h.userAgents {
+		if re.MatchString(ua) {
+			return true
+		}
+	}
+
+	return false
+}
 
-	if !h.shouldGzip(r) {
-		h.h.ServeHTTP(w, r)
+// addVary adds the request headers the response varies on
+// to the Vary header.
+func (h *handler) addVary(hdr http.Header) {
+	if len(h.userAgents) != 0 {
+		AddVary(hdr, "Accept-Encoding", "User-Agent")
+	} else {
+		AddVary(hdr, "Accept-Encoding")
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	noGzip := !h.shouldGzip(r)
+	if noGzip && h.notAcceptable != nil && !identityAcceptable(r.Header) {
+		h.addVary(w.Header())
+		h.notAcceptable.ServeHTTP(w, r)
+
+		h.observe(r, &responseInfo{
+			encoding: "identity",
+			reason:   reasonNotAcceptable,
+		})
 		return
 	}
 
+	debug := h.debugHeaders != nil && h.debugHeaders(r)
+
+	var digest *digester
+	if h.digests != nil && r.Method != http.MethodHead {
+		digest = newDigester(r.Header, h.digests)
+	}
+
 	gw := &responseWriter{
 		ResponseWriter: w,
 
 		h: h,
+		r: r,
 
 		buf: bufferPool.Get().(*[]byte),
+
+		noGzip: noGzip,
+
+		debug:  debug,
+		digest: digest,
+	}
+	if h.observer != nil || debug {
+		gw.info = new(responseInfo)
 	}
 	defer func() {
-		if err := gw.Close(); err != nil {
+		err := gw.Close()
+		gw.setErr(err)
+
+		switch {
+		case h.errorHandler != nil:
+			if gw.err != nil {
+				h.errorHandler(r, gw.err)
+			}
+		case err != nil:
 			httputils.RequestLogf(r, "gziphandler: error closing writer: %#v", err)
 		}
-	}()
-
-	var rw http.ResponseWriter = gw
 
-	_, cok := w.(http.CloseNotifier)
-	_, hok := w.(http.Hijacker)
-	_, pok := w.(http.Pusher)
-
-	switch {
-	case cok && hok:
-		rw = closeNotifyHijackResponseWriter{gw}
-	case cok && pok:
-		rw = closeNotifyPusherResponseWriter{gw}
-	case cok:
-		rw = closeNotifyResponseWriter{gw}
-	case hok:
-		rw = hijackResponseWriter{gw}
-	case pok:
-		rw = pusherResponseWriter{gw}
-	}
+		if gw.info != nil {
+			gw.info.err = gw.err
+			h.observe(r, gw.info)
+		}
+	}()
 
-	h.h.ServeHTTP(rw, r)
+	h.h.ServeHTTP(wrapResponseWriter(gw), r)
 }
 
 // Gzip wraps an HTTP handler, to transparently gzip the
@@ -415,8 +1374,11 @@ func Gzip(h http.Handler, opts ...Option) http.Handler {
 	gzh := &handler{
 		h: h,
 		config: config{
-			level:   DefaultCompression,
-			minSize: defaultMinSize,
+			level:        DefaultCompression,
+			minSize:      defaultMinSize,
+			excludeTypes: defaultExcludeContentTypes,
+
+			streamingTypes: defaultStreamingContentTypes,
 		},
 	}
 
@@ -440,8 +1402,39 @@ func Wrapper(opts ...Option) func(http.Handler) http.Handler {
 type config struct {
 	level        int
 	minSize      int
+	minSaving    float64
 	contentTypes []string
+	excludeTypes []string
 	shouldGzip   func(*http.Request) ShouldGzipType
+	pathRules    []pathRule
+	userAgents   []*regexp.Regexp
+
+	streamingTypes []string
+	flushInterval  time.Duration
+	bufferedFlush  BufferedFlushType
+
+	decoders map[string]Decoder
+
+	notAcceptable http.Handler
+	errorHandler  func(*http.Request, error)
+
+	observer func(*http.Request, *responseInfo)
+
+	debugHeaders func(*http.Request) bool
+
+	digests []string
+
+	gzipHeader func(*http.Request, http.Header, *gzip.Header)
+
+	parallel *parallelConfig
+
+	writeBufferSize int
+}
+
+type parallelConfig struct {
+	threshold   int64
+	blockSize   int
+	concurrency int
 }
 
 // Option customizes the behaviour of the gzip handler.
@@ -479,6 +1472,33 @@ func MinSize(size int) Option {
 	}
 }
 
+// MinSaving specifies the minimum estimated reduction in
+// size, as a fraction of the uncompressed size, for a
+// response to be compressed. Responses that are estimated
+// to compress less than this will not be compressed.
+//
+// The estimate is made from the byte entropy of the first
+// few kilobytes of the response. It is intended to avoid
+// wasting CPU on random, encrypted or already compressed
+// data that is served with a generic Content-Type like
+// application/octet-stream. The estimate is unreliable
+// for so few bytes, so at least 1 KiB of the response is
+// buffered before deciding, even if MinSize is smaller.
+// Shorter responses that reach MinSize are always
+// compressed.
+//
+// If saving is zero, no estimate is made. This is the
+// default.
+func MinSaving(saving float64) Option {
+	if saving < 0 || saving > 1 {
+		panic("gziphandler: minimum saving must be between 0 and 1")
+	}
+
+	return func(c *config) {
+		c.minSaving = saving
+	}
+}
+
 // ContentTypes specifies a list of MIME types to compare
 // the Content-Type header to before compressing. If none
 // match, the response will be returned as-is.
@@ -495,7 +1515,8 @@ func MinSize(size int) Option {
 // will match 'text/html; charset=utf-8'.
 //
 // By default, responses are gzipped regardless of
-// Content-Type.
+// Content-Type, unless the Content-Type is excluded by
+// ExcludeContentTypes.
 func ContentTypes(types []string) Option {
 	types = append([]string(nil), types...)
 
@@ -504,6 +1525,409 @@ func ContentTypes(types []string) Option {
 	}
 }
 
+// defaultExcludeContentTypes is a list of MIME types that
+// are, almost without exception, already compressed.
+var defaultExcludeContentTypes = []string{
+	"application/gzip",
+	"application/ogg",
+	"application/vnd.rar",
+	"application/x-7z-compressed",
+	"application/x-bzip2",
+	"application/x-gzip",
+	"application/x-rar-compressed",
+	"application/x-xz",
+	"application/zip",
+	"application/zstd",
+	"audio/*",
+	"font/woff",
+	"font/woff2",
+	"image/avif",
+	"image/gif",
+	"image/heic",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"video/*",
+}
+
+// DefaultExcludedContentTypes returns the list of MIME
+// types that are excluded from compression by default.
+// It is intended to be extended and passed to
+// ExcludeContentTypes.
+func DefaultExcludedContentTypes() []string {
+	return append([]string(nil), defaultExcludeContentTypes...)
+}
+
+// ExcludeContentTypes specifies a list of MIME types to
+// compare the Content-Type header to before compressing.
+// If any match, the response will be returned as-is. This
+// takes precedence over ContentTypes.
+//
+// MIME types are compared in the same manner as
+// ContentTypes.
+//
+// By default, common image, audio, video, font and archive
+// formats which are already compressed are excluded. See
+// DefaultExcludedContentTypes. If types is empty, no
+// Content-Type will be excluded.
+func ExcludeContentTypes(types []string) Option {
+	types = append([]string(nil), types...)
+
+	return func(c *config) {
+		c.excludeTypes = types
+	}
+}
+
+// defaultStreamingContentTypes is a list of MIME types
+// that are used for streaming responses.
+var defaultStreamingContentTypes = []string{
+	"text/event-stream",
+}
+
+// StreamingContentTypes specifies a list of MIME types to
+// compare the Content-Type header to. If any match, the
+// response is treated as a stream of server-sent events.
+//
+// Streaming responses are not buffered until MinSize is
+// reached, instead the decision whether to compress is
+// made on the first call to Write or Flush. When
+// compressing, the gzip writer and the underlying
+// http.ResponseWriter are flushed after every event, i.e.
+// whenever a blank line is written, so that clients
+// receive each event promptly.
+//
+// MIME types are compared in the same manner as
+// ContentTypes. The Content-Type header must be set
+// explicitly before the first call to Write or Flush.
+//
+// By default, only text/event-stream responses are
+// treated as streaming. If types is empty, no response
+// will be.
+func StreamingContentTypes(types []string) Option {
+	types = append([]string(nil), types...)
+
+	return func(c *config) {
+		c.streamingTypes = types
+	}
+}
+
+// FlushInterval specifies the maximum time that
+// compressed data may be held by the gzip writer before it
+// is flushed to the client. Once compression has started,
+// a timer flushes the gzip writer and the underlying
+// http.ResponseWriter every interval if data has been
+// written since the last flush.
+//
+// This is useful for responses, like NDJSON exports, that
+// are written slowly over a long period where clients may
+// otherwise time out waiting for data.
+//
+// Each flush emits a few bytes of framing into the
+// compressed stream and so reduces the compression ratio.
+//
+// If d is zero, the response is only flushed when the
+// handler calls Flush. This is the default.
+func FlushInterval(d time.Duration) Option {
+	if d < 0 {
+		panic("gziphandler: flush interval must not be negative")
+	}
+
+	return func(c *config) {
+		c.flushInterval = d
+	}
+}
+
+// BufferedFlush controls the behaviour of Flush before
+// MinSize is reached.
+//
+// By default, Flush is a no-op until MinSize is reached
+// so that small responses are not compressed. This can
+// delay the first bytes of a long-polling response
+// indefinitely if the body stays small. With
+// DecideOnFlush, Flush instead decides whether to compress
+// the response and flushes it. MinSize is ignored when
+// making this decision, so a small body that is flushed
+// will be compressed, unless excluded for another reason.
+//
+// Streaming responses, see StreamingContentTypes, always
+// behave as with DecideOnFlush.
+func BufferedFlush(typ BufferedFlushType) Option {
+	if typ != IgnoreBufferedFlush && typ != DecideOnFlush {
+		panic("gziphandler: invalid buffered flush type requested")
+	}
+
+	return func(c *config) {
+		c.bufferedFlush = typ
+	}
+}
+
+// Transcode enables the decoding of responses that have a
+// content coding the client doesn't accept, such as those
+// from an upstream server behind httputil.ReverseProxy.
+//
+// By default, responses with a Content-Encoding header are
+// passed through as is. With Transcode, if the client
+// doesn't accept the content coding, the response is
+// decoded with the matching Decoder and then compressed
+// with gzip, if the client accepts it, or sent as is.
+// Responses with a content coding the client accepts, or
+// that can't be decoded, and partial responses are still
+// passed through.
+//
+// When a response is transcoded, the Content-Length
+// header is removed, a strong ETag is made weak and
+// Accept-Encoding is added to the Vary header. The
+// decision whether to compress the decoded response is
+// made immediately, without regard for MinSize or
+// MinSaving, as the response was already compressed, and
+// only if the Content-Type header is set.
+//
+// If decoders is nil, DefaultDecoders is used.
+func Transcode(decoders map[string]Decoder) Option {
+	if decoders == nil {
+		decoders = DefaultDecoders()
+	} else {
+		decoders = copyDecoders(decoders)
+	}
+
+	return func(c *config) {
+		c.decoders = decoders
+	}
+}
+
+// Decompress enables the decoding of gzip responses for
+// clients that don't accept gzip. This allows handlers to
+// store and serve data that is already compressed with
+// gzip, setting the Content-Encoding header, regardless of
+// the client.
+//
+// It is equivalent to Transcode with only a gzip decoder.
+// Responses to clients that accept gzip are passed
+// through as is. Partial responses, with a status of 206,
+// are never decoded, so handlers that serve compressed
+// data should ignore the Range header of requests from
+// clients that don't accept gzip.
+func Decompress() Option {
+	return Transcode(map[string]Decoder{
+		"gzip": gzipDecoder,
+	})
+}
+
+func copyDecoders(decoders map[string]Decoder) map[string]Decoder {
+	c := make(map[string]Decoder, len(decoders))
+	for coding, decode := range decoders {
+		c[coding] = decode
+	}
+
+	return c
+}
+
+// StrictNegotiation makes the handler respond with 406 Not
+// Acceptable when the client accepts neither gzip nor the
+// identity content coding, as with an Accept-Encoding
+// header of "gzip;q=0, identity;q=0" or "*;q=0".
+//
+// The notAcceptable handler is called in place of the
+// wrapped handler. If it is nil, a plain text response
+// listing the supported content codings is sent.
+//
+// By default, the handler is lenient and such clients
+// receive an uncompressed response. Even with
+// StrictNegotiation, responses to clients that accept
+// gzip may be sent uncompressed, for instance if they are
+// smaller than MinSize.
+func StrictNegotiation(notAcceptable http.Handler) Option {
+	if notAcceptable == nil {
+		notAcceptable = http.HandlerFunc(notAcceptableHandler)
+	}
+
+	return func(c *config) {
+		c.notAcceptable = notAcceptable
+	}
+}
+
+// ErrorHandler specifies a function to be called with the
+// first error that occurred while writing a response. It
+// is called once the wrapped handler has returned and the
+// response has been closed.
+//
+// Errors from writing to the underlying
+// http.ResponseWriter, such as when the client has
+// disconnected, are a *WriteError. Other errors from the
+// gzip encoder are an *EncoderError, and errors decoding
+// a response for Transcode are a *DecoderError. Errors
+// reading the io.Reader passed to ReadFrom, such as by
+// io.Copy, are reported as is.
+//
+// The errors are also returned to the wrapped handler
+// from Write and ReadFrom.
+//
+// By default, only errors that occur when the response is
+// closed are logged, with httputils.RequestLogf.
+func ErrorHandler(fn func(*http.Request, error)) Option {
+	return func(c *config) {
+		c.errorHandler = fn
+	}
+}
+
+// DebugHeaders adds headers describing how the response
+// was compressed to requests for which fn returns true. It
+// is meant for diagnosing problems and fn should only
+// return true for trusted requests, such as those with an
+// internal header or from an internal IP range.
+//
+// The X-Compression header has the content coding of the
+// response, the gzip compression level, why the response
+// wasn't compressed, the content coding that was decoded
+// for Transcode, the number of bytes written by the
+// wrapped handler and the number of bytes written to the
+// client. For example:
+//
+//	X-Compression: gzip;level=6;in=10240;out=2048
+//	X-Compression: identity;reason=min-size;in=12;out=12
+//
+// The Server-Timing header has the time, in milliseconds,
+// spent compressing the response. For example:
+//
+//	Server-Timing: gzip;dur=1.300
+//
+// The sizes are only known once the response is complete,
+// so the headers are sent as HTTP trailers, declared in
+// the Trailer header, unless the entire response was
+// buffered. Trailers can't be sent if the wrapped handler
+// sets Content-Length on an uncompressed HTTP/1.1
+// response.
+func DebugHeaders(fn func(*http.Request) bool) Option {
+	return func(c *config) {
+		c.debugHeaders = fn
+	}
+}
+
+// Digests adds Content-Digest and Repr-Digest fields, as
+// defined in RFC 9530, to responses. The supported
+// algorithms are sha-256 and sha-512.
+//
+// Content-Digest is a digest of the response body as
+// sent, after any compression. Repr-Digest is a digest of
+// the selected representation which, as Content-Encoding
+// is part of the representation, is the same as
+// Content-Digest for a complete response. Neither is a
+// digest of the uncompressed response unless it wasn't
+// compressed. Repr-Digest is not sent for partial
+// responses.
+//
+// If the request has a Want-Content-Digest or
+// Want-Repr-Digest header, only the requested fields are
+// sent, with the algorithm the client most prefers.
+// Otherwise both are sent with every algorithm in algs.
+// Fields that the wrapped handler sets are left alone.
+//
+// The digests are only known once the response is
+// complete, so they are sent as HTTP trailers, declared
+// in the Trailer header, unless the entire response was
+// buffered. Trailers can't be sent if the wrapped handler
+// sets Content-Length on an uncompressed HTTP/1.1
+// response.
+func Digests(algs []string) Option {
+	if len(algs) == 0 {
+		panic("gziphandler: no digest algorithms")
+	}
+
+	for _, alg := range algs {
+		if _, ok := digestAlgorithms[alg]; !ok {
+			panic("gziphandler: unsupported digest algorithm " + strconv.Quote(alg))
+		}
+	}
+
+	algs = append([]string(nil), algs...)
+	return func(c *config) {
+		c.digests = algs
+	}
+}
+
+// GzipHeader allows the metadata in the gzip header of each
+// compressed response to be set. fn is called with the
+// request, the response header and the gzip header before
+// the response is compressed. InferGzipHeader may be used
+// to set it from the response header.
+//
+// Any Name or Comment that can't be stored in the gzip
+// header, as it isn't Latin-1 or contains a NUL character,
+// is ignored.
+//
+// By default, the gzip header has no metadata and the OS
+// is unknown.
+func GzipHeader(fn func(r *http.Request, h http.Header, gh *gzip.Header)) Option {
+	return func(c *config) {
+		c.gzipHeader = fn
+	}
+}
+
+// ParallelCompression enables compressing large responses
+// using multiple goroutines.
+//
+// Once a response exceeds threshold bytes, the rest of it
+// is split into blocks of blockSize bytes which are
+// compressed concurrently, by up to concurrency goroutines
+// per response. The result is a standard gzip stream,
+// slightly larger than it would otherwise be as each block
+// is compressed independently. Each response buffers up
+// to concurrency blocks, along with their compressed
+// output.
+//
+// If blockSize is zero, blocks are 1 MiB. If concurrency
+// is zero, runtime.GOMAXPROCS is used. Small blocks
+// compress poorly, so blockSize should be no less than
+// 64 KiB.
+//
+// Flushing a response compresses and writes any partial
+// block, which waits for all blocks being compressed.
+func ParallelCompression(threshold, blockSize, concurrency int) Option {
+	if threshold < 0 || blockSize < 0 || concurrency < 0 {
+		panic("gziphandler: invalid parallel compression parameters")
+	}
+
+	if blockSize == 0 {
+		blockSize = defaultParallelBlockSize
+	}
+
+	if concurrency == 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	pc := &parallelConfig{
+		threshold:   int64(threshold),
+		blockSize:   blockSize,
+		concurrency: concurrency,
+	}
+	return func(c *config) {
+		c.parallel = pc
+	}
+}
+
+// WriteBufferSize coalesces writes to a compressed response
+// that are smaller than size bytes in a buffer before they
+// are compressed. This helps handlers that make many small
+// writes, such as with html/template or json.Encoder, as
+// the gzip writer is much less efficient with small writes.
+//
+// The buffer is written when it is full and when the
+// response is flushed or closed. As writes are buffered,
+// an error writing the response may only be returned from
+// a later call to Write.
+//
+// If size is zero, the default, writes are not buffered.
+// A size of a few kilobytes is usually enough.
+func WriteBufferSize(size int) Option {
+	if size < 0 {
+		panic("gziphandler: write buffer size must not be negative")
+	}
+
+	return func(c *config) {
+		c.writeBufferSize = size
+	}
+}
+
 // ShouldGzip provides control over when the handler should
 // return a gzipped response. It allows handlers to implement
 // logic that doesn't consult the request's Accept-Encoding
@@ -520,6 +1944,159 @@ func ShouldGzip(fn func(*http.Request) ShouldGzipType) Option {
 	}
 }
 
+type pathRule struct {
+	match func(path string) bool
+	typ   ShouldGzipType
+}
+
+// PathPrefixes applies typ to any request whose URL path
+// begins with one of the given prefixes. Prefixes are
+// compared as with strings.HasPrefix, so /metrics will
+// also match /metrics/foo and /metricsfoo.
+//
+// PathPrefixes, PathPatterns and PathExtensions may be
+// given multiple times. The rules are evaluated in the
+// order given and the first rule to match is used. A rule
+// with a type of NegotiateGzip can be used to exempt paths
+// from later rules.
+//
+// Path rules are evaluated after ShouldGzip, and only if
+// it returns NegotiateGzip. Like ShouldGzip, path rules do
+// not affect MinSize or ContentTypes.
+func PathPrefixes(prefixes []string, typ ShouldGzipType) Option {
+	prefixes = append([]string(nil), prefixes...)
+
+	return addPathRule(typ, func(p string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(p, prefix) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// PathPatterns applies typ to any request whose URL path
+// matches one of the given shell patterns. The syntax of
+// patterns is the same as in path.Match and they must
+// match the entire path, i.e. use /static/*.png rather
+// than *.png. PathPatterns panics if any pattern is
+// malformed.
+//
+// See PathPrefixes for how path rules are evaluated.
+func PathPatterns(patterns []string, typ ShouldGzipType) Option {
+	patterns = append([]string(nil), patterns...)
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			panic("gziphandler: invalid path pattern " + strconv.Quote(pattern))
+		}
+	}
+
+	return addPathRule(typ, func(p string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// PathExtensions applies typ to any request whose URL path
+// ends in one of the given file extensions. Extensions are
+// compared in a case-insensitive manner and may be given
+// with or without the leading dot, i.e. png and .PNG are
+// equivalent.
+//
+// See PathPrefixes for how path rules are evaluated.
+func PathExtensions(exts []string, typ ShouldGzipType) Option {
+	exts = append([]string(nil), exts...)
+
+	for i, ext := range exts {
+		if !strings.HasPrefix(ext, ".") {
+			exts[i] = "." + ext
+		}
+	}
+
+	return addPathRule(typ, func(p string) bool {
+		ext := path.Ext(p)
+		if ext == "" {
+			return false
+		}
+
+		for _, e := range exts {
+			if strings.EqualFold(ext, e) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+func addPathRule(typ ShouldGzipType, match func(string) bool) Option {
+	return func(c *config) {
+		c.pathRules = append(c.pathRules, pathRule{
+			match: match,
+			typ:   typ,
+		})
+	}
+}
+
+// defaultExcludeUserAgents is a list of User-Agent
+// patterns for clients which are known to mishandle gzip
+// despite advertising support for it.
+var defaultExcludeUserAgents = []string{
+	// Netscape 4.06-4.08 fail to decompress anything
+	// other than text/html.
+	`^Mozilla/4\.0[678]`,
+	// Internet Explorer 6 and earlier fail to decompress
+	// some responses, particularly when cached.
+	`\bMSIE [1-6]\.`,
+}
+
+// DefaultExcludedUserAgents returns a list of User-Agent
+// patterns for clients which are known to mishandle gzip.
+// It is intended to be extended and passed to
+// ExcludeUserAgents.
+func DefaultExcludedUserAgents() []string {
+	return append([]string(nil), defaultExcludeUserAgents...)
+}
+
+// ExcludeUserAgents specifies a list of regular
+// expressions to match the request's User-Agent header
+// against. If any match, the response will not be
+// compressed. The syntax of patterns is the same as in
+// the regexp package. ExcludeUserAgents panics if any
+// pattern is malformed.
+//
+// When any patterns are given, a Vary: User-Agent header
+// will be added to all responses so that shared caches do
+// not serve a compressed response to an excluded client.
+// This considerably reduces the effectiveness of such
+// caches and so no User-Agent is excluded by default.
+//
+// User-Agent patterns are evaluated after ShouldGzip and
+// any path rules, and only if both return NegotiateGzip.
+func ExcludeUserAgents(patterns []string) Option {
+	userAgents := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			panic("gziphandler: invalid user agent pattern " + strconv.Quote(pattern))
+		}
+
+		userAgents = append(userAgents, re)
+	}
+
+	return func(c *config) {
+		c.userAgents = userAgents
+	}
+}
+
 // ShouldGzipType controls how the handler determines gzip
 // support.
 type ShouldGzipType int
@@ -538,50 +2115,21 @@ const (
 	ForceGzip
 )
 
-type (
-	// Each of these structs is intentionally small (1 pointer wide) so
-	// as to fit inside an interface{} without causing an allocaction.
-	closeNotifyResponseWriter       struct{ *responseWriter }
-	hijackResponseWriter            struct{ *responseWriter }
-	pusherResponseWriter            struct{ *responseWriter }
-	closeNotifyHijackResponseWriter struct{ *responseWriter }
-	closeNotifyPusherResponseWriter struct{ *responseWriter }
-)
+// BufferedFlushType controls the behaviour of Flush before
+// MinSize is reached, see BufferedFlush.
+type BufferedFlushType int
 
-var (
-	_ http.CloseNotifier = closeNotifyResponseWriter{}
-	_ http.CloseNotifier = closeNotifyHijackResponseWriter{}
-	_ http.CloseNotifier = closeNotifyPusherResponseWriter{}
-	_ http.Hijacker      = hijackResponseWriter{}
-	_ http.Hijacker      = closeNotifyHijackResponseWriter{}
-	_ http.Pusher        = pusherResponseWriter{}
-	_ http.Pusher        = closeNotifyPusherResponseWriter{}
+const (
+	// IgnoreBufferedFlush makes Flush a no-op until
+	// MinSize is reached.
+	IgnoreBufferedFlush BufferedFlushType = iota
+
+	// DecideOnFlush makes Flush decide whether to compress
+	// the response based on what has been written so far,
+	// ignoring MinSize.
+	//
+	// If the Content-Type header is not set and nothing
+	// has been written, Flush remains a no-op as the
+	// Content-Type can't be inferred.
+	DecideOnFlush
 )
-
-func (w closeNotifyResponseWriter) CloseNotify() <-chan bool {
-	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
-}
-
-func (w closeNotifyHijackResponseWriter) CloseNotify() <-chan bool {
-	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
-}
-
-func (w closeNotifyPusherResponseWriter) CloseNotify() <-chan bool {
-	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
-}
-
-func (w hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	return w.ResponseWriter.(http.Hijacker).Hijack()
-}
-
-func (w closeNotifyHijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	return w.ResponseWriter.(http.Hijacker).Hijack()
-}
-
-func (w pusherResponseWriter) Push(target string, opts *http.PushOptions) error {
-	return w.ResponseWriter.(http.Pusher).Push(target, opts)
-}
-
-func (w closeNotifyPusherResponseWriter) Push(target string, opts *http.PushOptions) error {
-	return w.ResponseWriter.(http.Pusher).Push(target, opts)
-}
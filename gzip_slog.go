@@ -0,0 +1,98 @@
+//go:build go1.21
+// +build go1.21
+
+package gziphandler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Logger logs the compression decision for each response
+// to logger.
+//
+// A debug level record is logged for each response with
+// the attributes:
+//   - encoding: the content coding of the response,
+//     identity if it has none;
+//   - compression_level: the gzip compression level, with
+//     DefaultCompression reported as 6, if encoding is
+//     gzip;
+//   - reason: why the response wasn't compressed with
+//     gzip, one of not-accepted, not-acceptable, encoded,
+//     content-type, min-size or min-saving;
+//   - transcoded_from: the content coding that was
+//     decoded, see Transcode;
+//   - bytes_in: the number of bytes written by the
+//     wrapped handler;
+//   - bytes_out: the number of bytes written to the
+//     underlying http.ResponseWriter;
+//   - encode_duration: the time spent in the gzip encoder;
+//   - error: the first error that occurred while writing
+//     the response, if any.
+//
+// Attributes that are not applicable are omitted. An error
+// level record is also logged if the gzip encoder or a
+// Decoder fails. Errors writing to the underlying
+// http.ResponseWriter, such as when the client has
+// disconnected, are only logged at the debug level.
+//
+// Records are logged with the request's context.
+func Logger(logger *slog.Logger) Option {
+	if logger == nil {
+		panic("gziphandler: nil logger")
+	}
+
+	return func(c *config) {
+		c.observer = func(r *http.Request, info *responseInfo) {
+			logResponse(logger, r.Context(), info)
+		}
+	}
+}
+
+func logResponse(logger *slog.Logger, ctx context.Context, info *responseInfo) {
+	var (
+		encErr *EncoderError
+		decErr *DecoderError
+	)
+	if errors.As(info.err, &encErr) || errors.As(info.err, &decErr) {
+		logger.LogAttrs(ctx, slog.LevelError, "gziphandler: error writing response",
+			slog.String("encoding", info.encoding),
+			slog.Any("error", info.err))
+	}
+
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 8)
+	attrs = append(attrs, slog.String("encoding", info.encoding))
+
+	if info.encoding == "gzip" {
+		attrs = append(attrs, slog.Int("compression_level", debugLevel(info.level)))
+	}
+
+	if info.reason != "" {
+		attrs = append(attrs, slog.String("reason", info.reason))
+	}
+
+	if info.transcoded != "" {
+		attrs = append(attrs, slog.String("transcoded_from", info.transcoded))
+	}
+
+	attrs = append(attrs,
+		slog.Int64("bytes_in", info.in),
+		slog.Int64("bytes_out", info.out))
+
+	if info.encoding == "gzip" {
+		attrs = append(attrs, slog.Duration("encode_duration", info.encodeTime))
+	}
+
+	if info.err != nil {
+		attrs = append(attrs, slog.Any("error", info.err))
+	}
+
+	logger.LogAttrs(ctx, slog.LevelDebug, "gziphandler: compression decision", attrs...)
+}
@@ -0,0 +1,228 @@
+//go:build go1.21
+// +build go1.21
+
+package gziphandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeLogRecords decodes the records written by a
+// slog.JSONHandler.
+func decodeLogRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]interface{}
+		require.NoError(t, dec.Decode(&record))
+
+		delete(record, "time")
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestLogger(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		encoding       string
+		body           []byte
+		opts           []Option
+
+		expect map[string]interface{}
+	}{
+		{"gzip", "gzip", "text/plain", "", []byte(testBody), nil, map[string]interface{}{
+			"encoding":          "gzip",
+			"compression_level": float64(6),
+			"bytes_in":          float64(len(testBody)),
+			"bytes_out":         float64(len(gzipStrLevel(testBody, DefaultCompression))),
+		}},
+		{"not-accepted", "", "text/plain", "", []byte(testBody), nil, map[string]interface{}{
+			"encoding":  "identity",
+			"reason":    "not-accepted",
+			"bytes_in":  float64(len(testBody)),
+			"bytes_out": float64(len(testBody)),
+		}},
+		{"min-size", "gzip", "text/plain", "", []byte("test"), nil, map[string]interface{}{
+			"encoding":  "identity",
+			"reason":    "min-size",
+			"bytes_in":  float64(4),
+			"bytes_out": float64(4),
+		}},
+		{"content-type", "gzip", "image/png", "", []byte(testBody), []Option{ContentTypes([]string{"text/plain"})}, map[string]interface{}{
+			"encoding":  "identity",
+			"reason":    "content-type",
+			"bytes_in":  float64(len(testBody)),
+			"bytes_out": float64(len(testBody)),
+		}},
+		{"encoded", "gzip", "text/plain", "br", []byte(testBody), nil, map[string]interface{}{
+			"encoding":  "br",
+			"reason":    "encoded",
+			"bytes_in":  float64(len(testBody)),
+			"bytes_out": float64(len(testBody)),
+		}},
+		{"transcoded", "", "text/plain", "deflate", zlibStr(testBody), []Option{Transcode(nil)}, map[string]interface{}{
+			"encoding":        "identity",
+			"reason":          "not-accepted",
+			"transcoded_from": "deflate",
+			"bytes_in":        float64(len(testBody)),
+			"bytes_out":       float64(len(testBody)),
+		}},
+		{"not-acceptable", "identity;q=0", "text/plain", "", []byte(testBody), []Option{StrictNegotiation(nil)}, map[string]interface{}{
+			"encoding":  "identity",
+			"reason":    "not-acceptable",
+			"bytes_in":  float64(0),
+			"bytes_out": float64(0),
+		}},
+	} {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tc.contentType)
+			if tc.encoding != "" {
+				w.Header().Set("Content-Encoding", tc.encoding)
+			}
+
+			w.Write(tc.body)
+		}), append(tc.opts, Logger(logger))...)
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		records := decodeLogRecords(t, &buf)
+		require.Len(t, records, 1, tc.name)
+
+		record := records[0]
+		assert.Equal(t, "DEBUG", record["level"], tc.name)
+		assert.Equal(t, "gziphandler: compression decision", record["msg"], tc.name)
+
+		if tc.expect["encoding"] == "gzip" {
+			assert.Contains(t, record, "encode_duration", tc.name)
+			delete(record, "encode_duration")
+		}
+
+		delete(record, "level")
+		delete(record, "msg")
+		assert.Equal(t, tc.expect, record, tc.name)
+	}
+}
+
+func TestLoggerDecoderError(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+		io.WriteString(w, testBody)
+	}), Decompress(), Logger(logger))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Only the error record is logged at the default
+	// level.
+	records := decodeLogRecords(t, &buf)
+	require.Len(t, records, 1)
+
+	assert.Equal(t, "ERROR", records[0]["level"])
+	assert.Equal(t, "gziphandler: error writing response", records[0]["msg"])
+	assert.Equal(t, "identity", records[0]["encoding"])
+	assert.Contains(t, records[0]["error"], "gziphandler: decoder error")
+}
+
+func TestLoggerWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, testBody)
+	}), Logger(logger))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	handler.ServeHTTP(&errorResponseWriter{ResponseRecorder: httptest.NewRecorder()}, req)
+
+	// Write errors are only logged with the compression
+	// decision.
+	records := decodeLogRecords(t, &buf)
+	require.Len(t, records, 1)
+
+	assert.Equal(t, "DEBUG", records[0]["level"])
+	assert.Contains(t, records[0]["error"], errTestWrite.Error())
+}
+
+type testContextKey struct{}
+
+// contextHandler is a slog.Handler that records the
+// contexts it is called with.
+type contextHandler struct {
+	slog.Handler
+	ctxs []context.Context
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.ctxs = append(h.ctxs, ctx)
+	return h.Handler.Handle(ctx, r)
+}
+
+func TestLoggerContext(t *testing.T) {
+	h := &contextHandler{Handler: slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug})}
+
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}), Logger(slog.New(h)))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req = req.WithContext(context.WithValue(req.Context(), testContextKey{}, "test"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, h.ctxs, 1)
+	assert.Equal(t, "test", h.ctxs[0].Value(testContextKey{}))
+}
+
+func TestLogResponseEncoderError(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	logResponse(logger, context.Background(), &responseInfo{
+		encoding: "gzip",
+		level:    DefaultCompression,
+		err:      &EncoderError{Err: errors.New("encoder failed")},
+	})
+
+	records := decodeLogRecords(t, &buf)
+	require.Len(t, records, 1)
+
+	assert.Equal(t, "ERROR", records[0]["level"])
+	assert.Equal(t, "gzip", records[0]["encoding"])
+	assert.Equal(t, "gziphandler: encoder error: encoder failed", records[0]["error"])
+}
+
+func TestLoggerPanicsForNil(t *testing.T) {
+	assert.Panics(t, func() {
+		Logger(nil)
+	})
+}
@@ -4,13 +4,20 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -158,6 +165,79 @@ func TestGzipHandlerContentLength(t *testing.T) {
 	assert.Equal(t, gzipStrLevel(testBody, DefaultCompression), body)
 }
 
+func TestGzipHandlerPassThroughContentLength(t *testing.T) {
+	handler := newTestHandler("test", MinSize(13))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	res := resp.Result()
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "4", res.Header.Get("Content-Length"))
+	assert.Equal(t, "test", resp.Body.String())
+
+	handler = Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4")
+		io.WriteString(w, "test")
+	}), MinSize(13))
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, []string{"4"}, resp.Result().Header["Content-Length"])
+
+	for _, code := range []int{http.StatusNoContent, http.StatusNotModified} {
+		handler = Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}), MinSize(13))
+
+		resp = httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		_, ok := resp.Result().Header["Content-Length"]
+		assert.False(t, ok, "Content-Length set for %d response", code)
+	}
+}
+
+func TestGzipHandlerPassThroughContentLengthServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	// The body is larger than the buffers net/http uses
+	// before it falls back to chunking, so it would only
+	// set Content-Length itself if it saw the entire body.
+	body := strings.Repeat("a", 4096)
+
+	for _, http2 := range []bool{false, true} {
+		srv := httptest.NewUnstartedServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}), MinSize(8192)))
+		srv.EnableHTTP2 = http2
+		srv.StartTLS()
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err, "Unexpected error making http request")
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err, "Unexpected error making http request")
+
+		resBody, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err, "Unexpected error reading response body")
+		res.Body.Close()
+		srv.Close()
+
+		assert.Equal(t, http2, res.ProtoMajor == 2, "unexpected protocol %s", res.Proto)
+		assert.Equal(t, int64(len(body)), res.ContentLength, "for %s", res.Proto)
+		assert.Empty(t, res.TransferEncoding, "for %s", res.Proto)
+		assert.Equal(t, "", res.Header.Get("Content-Encoding"), "for %s", res.Proto)
+		assert.Equal(t, body, string(resBody), "for %s", res.Proto)
+	}
+}
+
 func TestGzipHandlerMinSize(t *testing.T) {
 	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		resp, _ := ioutil.ReadAll(r.Body)
@@ -194,6 +274,110 @@ func TestMinSizePanicsForInvalid(t *testing.T) {
 	}, "MinSize did not panic on negative size")
 }
 
+func TestMinSaving(t *testing.T) {
+	random := make([]byte, 8192)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, tc := range []struct {
+		body   string
+		saving float64
+		expect bool
+	}{
+		{testBody, 0, true},
+		{testBody, 0.1, true},
+		{string(random), 0, true},
+		{string(random), 0.1, false},
+		{string(random[:defaultMinSize]), 0.1, true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		newTestHandler(tc.body, MinSaving(tc.saving)).ServeHTTP(resp, req)
+
+		res := resp.Result()
+		if tc.expect {
+			assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"), "saving %v", tc.saving)
+		} else {
+			assert.Equal(t, "", res.Header.Get("Content-Encoding"), "saving %v", tc.saving)
+			assert.Equal(t, tc.body, resp.Body.String(), "saving %v", tc.saving)
+		}
+	}
+}
+
+func TestMinSavingSmallWrites(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := bytes.Repeat([]byte(testBody), 64*1024/len(testBody))
+
+	for _, tc := range []struct {
+		body   []byte
+		chunk  int
+		expect string
+	}{
+		{random, 200, ""},
+		{random, 512, ""},
+		{random, 1000, ""},
+		{random, 2048, ""},
+		{text, 200, "gzip"},
+		{text[:500], 200, "gzip"},
+		{random[:500], 200, "gzip"},
+	} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			for b := tc.body; len(b) != 0; {
+				n := tc.chunk
+				if len(b) < n {
+					n = len(b)
+				}
+
+				w.Write(b[:n])
+				b = b[n:]
+			}
+		}), MinSaving(0.1))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, tc.expect, res.Header.Get("Content-Encoding"), "len %d, chunk %d", len(tc.body), tc.chunk)
+
+		body := resp.Body.Bytes()
+		if tc.expect == "gzip" {
+			zr, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err, "len %d, chunk %d", len(tc.body), tc.chunk)
+
+			body, err = ioutil.ReadAll(zr)
+			require.NoError(t, err, "len %d, chunk %d", len(tc.body), tc.chunk)
+		}
+
+		assert.Equal(t, tc.body, body, "len %d, chunk %d", len(tc.body), tc.chunk)
+	}
+}
+
+func TestEstimateSaving(t *testing.T) {
+	assert.Equal(t, 1.0, estimateSaving(nil, nil))
+	assert.Equal(t, 1.0, estimateSaving(bytes.Repeat([]byte("a"), 512), bytes.Repeat([]byte("a"), 512)))
+	assert.InDelta(t, 0.875, estimateSaving([]byte("ab"), bytes.Repeat([]byte("ab"), 1024)), 1e-9)
+
+	random := make([]byte, 2*entropySampleLen)
+	rand.New(rand.NewSource(1)).Read(random)
+	assert.InDelta(t, 0, estimateSaving(random[:100], random[100:]), 0.01)
+	assert.Equal(t, 1.0, estimateSaving(random[:defaultMinSize], nil))
+	assert.Equal(t, 1.0, estimateSaving(random[:100], random[100:minEntropySampleLen-1]))
+}
+
+func TestMinSavingPanicsForInvalid(t *testing.T) {
+	assert.PanicsWithValue(t, "gziphandler: minimum saving must be between 0 and 1", func() {
+		MinSaving(-0.5)
+	}, "MinSaving did not panic on negative saving")
+
+	assert.PanicsWithValue(t, "gziphandler: minimum saving must be between 0 and 1", func() {
+		MinSaving(1.5)
+	}, "MinSaving did not panic on saving greater than one")
+}
+
 func TestGzipDoubleClose(t *testing.T) {
 	h := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// call close here and it'll get called again interally by
@@ -305,6 +489,90 @@ func TestFlushBeforeWrite(t *testing.T) {
 	assert.Equal(t, gzipStrLevel(testBody, DefaultCompression), w.Body.Bytes())
 }
 
+func TestBufferedFlush(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		typ      BufferedFlushType
+		ct       string
+		body     string
+		flushed  bool
+		encoding string
+	}{
+		{"ignore", IgnoreBufferedFlush, "", "test", false, ""},
+		{"decide", DecideOnFlush, "", "test", true, "gzip"},
+		{"decide-content-type", DecideOnFlush, "text/plain", "", true, "gzip"},
+		{"decide-excluded", DecideOnFlush, "image/png", "test", true, ""},
+		{"decide-empty", DecideOnFlush, "", "", false, ""},
+	} {
+		var flushed bool
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.ct != "" {
+				w.Header().Set("Content-Type", tc.ct)
+			}
+
+			io.WriteString(w, tc.body)
+			w.(http.Flusher).Flush()
+		}), BufferedFlush(tc.typ))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(struct {
+			http.ResponseWriter
+			http.Flusher
+		}{
+			resp,
+			httpFlusherFunc(func() { flushed = true }),
+		}, req)
+
+		assert.Equal(t, tc.flushed, flushed, tc.name)
+
+		res := resp.Result()
+		assert.Equal(t, tc.encoding, res.Header.Get("Content-Encoding"), tc.name)
+
+		body := resp.Body.Bytes()
+		if tc.encoding == "gzip" {
+			zr, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err, tc.name)
+
+			body, err = ioutil.ReadAll(zr)
+			require.NoError(t, err, tc.name)
+		}
+
+		assert.Equal(t, tc.body, string(body), tc.name)
+	}
+}
+
+func TestBufferedFlushIgnoresMinSize(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "test")
+			if flush {
+				w.(http.Flusher).Flush()
+			}
+		}), BufferedFlush(DecideOnFlush), MinSize(1024))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		if flush {
+			assert.Equal(t, "gzip", resp.Result().Header.Get("Content-Encoding"), "flush %t", flush)
+		} else {
+			assert.Equal(t, "", resp.Result().Header.Get("Content-Encoding"), "flush %t", flush)
+			assert.Equal(t, "test", resp.Body.String(), "flush %t", flush)
+		}
+	}
+}
+
+func TestBufferedFlushPanicsForInvalid(t *testing.T) {
+	assert.PanicsWithValue(t, "gziphandler: invalid buffered flush type requested", func() {
+		BufferedFlush(-1)
+	}, "BufferedFlush did not panic with invalid type")
+}
+
 func TestInferContentType(t *testing.T) {
 	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, "<!doc")
@@ -383,6 +651,14 @@ type httpPusherFunc func(target string, opts *http.PushOptions) error
 
 func (fn httpPusherFunc) Push(target string, opts *http.PushOptions) error { return fn(target, opts) }
 
+type readerFromFunc func(r io.Reader) (int64, error)
+
+func (fn readerFromFunc) ReadFrom(r io.Reader) (int64, error) { return fn(r) }
+
+type stringWriterFunc func(s string) (int, error)
+
+func (fn stringWriterFunc) WriteString(s string) (int, error) { return fn(s) }
+
 func TestResponseWriterTypes(t *testing.T) {
 	var closeNotified bool
 	closeNotifier := func() http.CloseNotifier {
@@ -477,6 +753,274 @@ func TestResponseWriterTypes(t *testing.T) {
 	assert.True(t, pushed, "Push did not call underlying http.Pusher")
 }
 
+func TestResponseWriterTypesExhaustive(t *testing.T) {
+	for mask := 0; mask <= responseWriterTypesMask; mask++ {
+		for _, compress := range []bool{false, true} {
+			var closeNotified, hijacked, pushed bool
+
+			rec := httptest.NewRecorder()
+			underlying := newTestResponseWriter(mask, rec,
+				httpCloseNotifierFunc(func() <-chan bool {
+					closeNotified = true
+					return nil
+				}),
+				httpHijackerFunc(func() (net.Conn, *bufio.ReadWriter, error) {
+					hijacked = true
+
+					// Fail so that the response is still
+					// written.
+					return nil, nil, http.ErrNotSupported
+				}),
+				httpPusherFunc(func(string, *http.PushOptions) error {
+					pushed = true
+					return nil
+				}))
+
+			var got int
+			handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !compress {
+					w.Header().Set("Content-Type", "image/png")
+				}
+
+				io.WriteString(w, testBody)
+
+				if c, ok := w.(http.CloseNotifier); ok {
+					got |= closeNotifyType
+					c.CloseNotify()
+				}
+
+				if h, ok := w.(http.Hijacker); ok {
+					got |= hijackType
+					h.Hijack()
+				}
+
+				if p, ok := w.(http.Pusher); ok {
+					got |= pusherType
+					p.Push("", nil)
+				}
+
+				w.(io.ReaderFrom).ReadFrom(bytes.NewReader([]byte(testBody)))
+
+				w.(io.StringWriter).WriteString(testBody)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			handler.ServeHTTP(underlying, req)
+
+			assert.Equal(t, mask, got, "mask %03b: wrong optional interfaces", mask)
+			assert.Equal(t, mask&closeNotifyType != 0, closeNotified, "mask %03b: CloseNotify", mask)
+			assert.Equal(t, mask&hijackType != 0, hijacked, "mask %03b: Hijack", mask)
+			assert.Equal(t, mask&pusherType != 0, pushed, "mask %03b: Push", mask)
+
+			body := rec.Body.Bytes()
+			if compress {
+				assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"), "mask %03b", mask)
+
+				zr, err := gzip.NewReader(rec.Body)
+				require.NoError(t, err, "mask %03b", mask)
+
+				body, err = ioutil.ReadAll(zr)
+				require.NoError(t, err, "mask %03b", mask)
+			}
+
+			assert.Equal(t, testBody+testBody+testBody, string(body), "mask %03b", mask)
+		}
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		var readFrom bool
+
+		rec := httptest.NewRecorder()
+		underlying := struct {
+			http.ResponseWriter
+			io.ReaderFrom
+		}{
+			rec,
+			readerFromFunc(func(r io.Reader) (int64, error) {
+				readFrom = true
+				return io.Copy(rec, r)
+			}),
+		}
+
+		var (
+			n   int64
+			err error
+		)
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !compress {
+				w.Header().Set("Content-Type", "image/png")
+			}
+
+			// Hide any WriterTo method so that
+			// io.Copy uses ReadFrom.
+			n, err = io.Copy(w, struct{ io.Reader }{
+				strings.NewReader(strings.Repeat(testBody, 100)),
+			})
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(underlying, req)
+
+		require.NoError(t, err, "compress: %t", compress)
+		assert.Equal(t, int64(100*len(testBody)), n, "compress: %t", compress)
+
+		// ReadFrom should only be passed through when
+		// not compressing.
+		assert.Equal(t, !compress, readFrom, "compress: %t", compress)
+
+		body := rec.Body.Bytes()
+		if compress {
+			assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+
+			zr, err := gzip.NewReader(rec.Body)
+			require.NoError(t, err)
+
+			body, err = ioutil.ReadAll(zr)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, strings.Repeat(testBody, 100), string(body), "compress: %t", compress)
+	}
+}
+
+func TestReadFromBuffered(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(io.ReaderFrom).ReadFrom(strings.NewReader("<!doc"))
+		w.(io.ReaderFrom).ReadFrom(strings.NewReader("type html>"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	res := resp.Result()
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(t, "<!doctype html>", resp.Body.String())
+}
+
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestReadFromError(t *testing.T) {
+	errRead := errors.New("read failed")
+
+	var err error
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err = w.(io.ReaderFrom).ReadFrom(io.MultiReader(
+			strings.NewReader(testBody), errorReader{errRead}))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, errRead, err)
+}
+
+func TestNewSourceReader(t *testing.T) {
+	f, err := ioutil.TempFile("", "gziphandler")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	lr := &io.LimitedReader{R: f, N: 10}
+	assert.Equal(t, io.Reader(f), newSourceReader(f), "*os.File was wrapped")
+	assert.Equal(t, io.Reader(lr), newSourceReader(lr), "*io.LimitedReader of *os.File was wrapped")
+
+	r := strings.NewReader(testBody)
+	assert.IsType(t, &sourceReader{}, newSourceReader(r))
+	assert.False(t, isSourceError(newSourceReader(r)))
+}
+
+func TestWriteString(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		var wroteString bool
+
+		rec := httptest.NewRecorder()
+		underlying := struct {
+			http.ResponseWriter
+			io.StringWriter
+		}{
+			rec,
+			stringWriterFunc(func(s string) (int, error) {
+				wroteString = true
+				return rec.WriteString(s)
+			}),
+		}
+
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !compress {
+				w.Header().Set("Content-Type", "image/png")
+			}
+
+			for i := 0; i < 100; i++ {
+				n, err := io.WriteString(w, testBody[:100])
+				assert.NoError(t, err)
+				assert.Equal(t, 100, n)
+			}
+
+			n, err := io.WriteString(w, strings.Repeat(testBody, 100))
+			assert.NoError(t, err)
+			assert.Equal(t, 100*len(testBody), n)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(underlying, req)
+
+		// WriteString should only be passed through when
+		// not compressing.
+		assert.Equal(t, !compress, wroteString, "compress: %t", compress)
+
+		body := rec.Body.Bytes()
+		if compress {
+			assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+
+			zr, err := gzip.NewReader(rec.Body)
+			require.NoError(t, err)
+
+			body, err = ioutil.ReadAll(zr)
+			require.NoError(t, err)
+		}
+
+		expect := strings.Repeat(testBody[:100], 100) + strings.Repeat(testBody, 100)
+		assert.Equal(t, expect, string(body), "compress: %t", compress)
+	}
+}
+
+func TestWriteStringBuffered(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(io.StringWriter).WriteString("<!doc")
+		w.(io.StringWriter).WriteString("type html>")
+	}), MinSize(len("<!doctype html")))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Add("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	res := resp.Result()
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(t, gzipStrLevel("<!doctype html>", DefaultCompression), resp.Body.Bytes())
+}
+
+func TestWriteStringPanicsInvariant(t *testing.T) {
+	assert.PanicsWithValue(t, "gziphandler: both buf and gw are non nil in call to WriteString", func() {
+		(&responseWriter{
+			gw:  new(gzip.Writer),
+			buf: new([]byte),
+		}).WriteString("")
+	}, "WriteString did not panic with both gw and buf non-nil")
+}
+
 func TestContentTypes(t *testing.T) {
 	for _, tt := range []struct {
 		name                 string
@@ -620,23 +1164,133 @@ func TestContentTypesCopies(t *testing.T) {
 	assert.False(t, &c.contentTypes[0] == &s[0], "ContentTypes returned same slice")
 }
 
-func TestGzipHandlerAlreadyCompressed(t *testing.T) {
-	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Encoding", "br")
-		io.WriteString(w, testBody)
-	}))
+func TestExcludeContentTypes(t *testing.T) {
+	png := "\x89PNG\x0D\x0A\x1A\x0A" + testBody
 
-	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
-	req.Header.Set("Accept-Encoding", "gzip")
-	res := httptest.NewRecorder()
-	handler.ServeHTTP(res, req)
+	for _, tt := range []struct {
+		name         string
+		contentType  string
+		body         string
+		opts         []Option
+		expectedGzip bool
+	}{
+		{
+			name:         "Default exclusion",
+			contentType:  "image/png",
+			expectedGzip: false,
+		},
+		{
+			name:         "Default exclusion with directive",
+			contentType:  "Image/PNG; foo=bar",
+			expectedGzip: false,
+		},
+		{
+			name:         "Default no-subtype exclusion",
+			contentType:  "video/mp4",
+			expectedGzip: false,
+		},
+		{
+			name:         "Default exclusion, sniffed",
+			body:         png,
+			expectedGzip: false,
+		},
+		{
+			name:         "Not excluded by default",
+			contentType:  "image/svg+xml",
+			expectedGzip: true,
+		},
+		{
+			name:         "Empty exclusion list",
+			contentType:  "image/png",
+			opts:         []Option{ExcludeContentTypes(nil)},
+			expectedGzip: true,
+		},
+		{
+			name:         "Empty exclusion list, sniffed",
+			body:         png,
+			opts:         []Option{ExcludeContentTypes(nil)},
+			expectedGzip: true,
+		},
+		{
+			name:         "Custom exclusion",
+			contentType:  "application/json",
+			opts:         []Option{ExcludeContentTypes([]string{"application/*"})},
+			expectedGzip: false,
+		},
+		{
+			name:         "Custom exclusion replaces default",
+			contentType:  "image/png",
+			opts:         []Option{ExcludeContentTypes([]string{"application/json"})},
+			expectedGzip: true,
+		},
+		{
+			name:        "Exclusion takes precedence",
+			contentType: "image/png",
+			opts: []Option{
+				ContentTypes([]string{"image/*"}),
+			},
+			expectedGzip: false,
+		},
+	} {
+		body := tt.body
+		if body == "" {
+			body = testBody
+		}
 
-	assert.Equal(t, "br", res.Result().Header.Get("Content-Encoding"))
-	assert.Equal(t, testBody, res.Body.String())
-}
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				w.Header().Set("Content-Type", tt.contentType)
+			}
 
-func TestReleaseBufferPanicsInvaraiant(t *testing.T) {
-	assert.PanicsWithValue(t, "gziphandler: w.buf is nil in call to emptyBuffer", func() {
+			io.WriteString(w, body)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		Gzip(handler, tt.opts...).ServeHTTP(resp, req)
+
+		res := resp.Result()
+		if tt.expectedGzip {
+			assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"), tt.name)
+		} else {
+			assert.Equal(t, "", res.Header.Get("Content-Encoding"), tt.name)
+			assert.Equal(t, body, resp.Body.String(), tt.name)
+		}
+	}
+}
+
+func TestExcludeContentTypesCopies(t *testing.T) {
+	s := []string{"application/example"}
+
+	var c config
+	ExcludeContentTypes(s)(&c)
+
+	require.NotEmpty(t, c.excludeTypes)
+	assert.False(t, &c.excludeTypes[0] == &s[0], "ExcludeContentTypes returned same slice")
+
+	d := DefaultExcludedContentTypes()
+	require.NotEmpty(t, d)
+	assert.False(t, &d[0] == &defaultExcludeContentTypes[0], "DefaultExcludedContentTypes returned same slice")
+}
+
+func TestGzipHandlerAlreadyCompressed(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, testBody)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	assert.Equal(t, "br", res.Result().Header.Get("Content-Encoding"))
+	assert.Equal(t, testBody, res.Body.String())
+}
+
+func TestReleaseBufferPanicsInvaraiant(t *testing.T) {
+	assert.PanicsWithValue(t, "gziphandler: w.buf is nil in call to emptyBuffer", func() {
 		new(responseWriter).releaseBuffer()
 	}, "releaseBuffer did not panic with nil buf")
 }
@@ -713,6 +1367,547 @@ func TestShouldGzip(t *testing.T) {
 	}
 }
 
+func TestPathRules(t *testing.T) {
+	opts := []Option{
+		PathPrefixes([]string{"/metrics/public"}, NegotiateGzip),
+		PathPrefixes([]string{"/metrics", "/healthz"}, SkipGzip),
+		PathPatterns([]string{"/static/*.png"}, SkipGzip),
+		PathExtensions([]string{"txt", ".JSON"}, ForceGzip),
+	}
+
+	for _, tc := range []struct {
+		path      string
+		advertise bool
+		expect    bool
+	}{
+		{"/whatever", false, false},
+		{"/whatever", true, true},
+		{"/metrics", true, false},
+		{"/metrics/foo", true, false},
+		{"/metrics/public", true, true},
+		{"/metrics/public", false, false},
+		{"/healthz", true, false},
+		{"/static/foo.png", true, false},
+		{"/static/foo/bar.png", true, true},
+		{"/foo.txt", false, true},
+		{"/foo.TXT", false, true},
+		{"/foo.json", false, true},
+		{"/metrics/foo.txt", false, false},
+		{"/foo.txt/bar", false, false},
+	} {
+		handler := newTestHandler(testBody, opts...)
+
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.advertise {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		if tc.expect {
+			assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"), "%+v", tc)
+		} else {
+			assert.Equal(t, "", res.Header.Get("Content-Encoding"), "%+v", tc)
+			assert.Equal(t, testBody, resp.Body.String(), "%+v", tc)
+		}
+	}
+}
+
+func TestPathRulesAfterShouldGzip(t *testing.T) {
+	handler := newTestHandler(testBody, ShouldGzip(func(r *http.Request) ShouldGzipType {
+		if r.URL.Query().Get("force") != "" {
+			return ForceGzip
+		}
+
+		return NegotiateGzip
+	}), PathPrefixes([]string{"/"}, SkipGzip))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, "", resp.Result().Header.Get("Content-Encoding"))
+
+	req = httptest.NewRequest(http.MethodGet, "/whatever?force=1", nil)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+}
+
+func TestPathPatternsPanicsForInvalid(t *testing.T) {
+	assert.PanicsWithValue(t, `gziphandler: invalid path pattern "/static/[.png"`, func() {
+		PathPatterns([]string{"/static/[.png"}, SkipGzip)
+	}, "PathPatterns did not panic on malformed pattern")
+}
+
+func TestExcludeUserAgents(t *testing.T) {
+	handler := newTestHandler(testBody, ExcludeUserAgents(append(DefaultExcludedUserAgents(), "^curl/")))
+
+	for _, tc := range []struct {
+		userAgent string
+		expect    bool
+	}{
+		{"", true},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0", true},
+		{"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.0)", true},
+		{"Mozilla/4.0 (compatible; MSIE 6.0; Windows NT 5.1)", false},
+		{"Mozilla/4.07 [en] (WinNT; I)", false},
+		{"curl/8.4.0", false},
+		{"libcurl/8.4.0", true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("User-Agent", tc.userAgent)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, []string{"Accept-Encoding, User-Agent"}, res.Header["Vary"], "%+v", tc)
+
+		if tc.expect {
+			assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"), "%+v", tc)
+		} else {
+			assert.Equal(t, "", res.Header.Get("Content-Encoding"), "%+v", tc)
+			assert.Equal(t, testBody, resp.Body.String(), "%+v", tc)
+		}
+	}
+}
+
+func TestExcludeUserAgentsNoVary(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	newTestHandler(testBody, ExcludeUserAgents(nil)).ServeHTTP(resp, req)
+
+	assert.Equal(t, []string{"Accept-Encoding"}, resp.Result().Header["Vary"])
+}
+
+func TestExcludeUserAgentsPanicsForInvalid(t *testing.T) {
+	assert.PanicsWithValue(t, `gziphandler: invalid user agent pattern "MSIE ["`, func() {
+		ExcludeUserAgents([]string{"MSIE ["})
+	}, "ExcludeUserAgents did not panic on malformed pattern")
+}
+
+func TestGzipHandlerVary(t *testing.T) {
+	for _, tc := range []struct {
+		vary   []string
+		expect []string
+	}{
+		{nil, []string{"Accept-Encoding"}},
+		{[]string{"Origin"}, []string{"Origin, Accept-Encoding"}},
+		{[]string{"accept-encoding"}, []string{"accept-encoding"}},
+		{[]string{"Origin", "Accept-Encoding"}, []string{"Origin, Accept-Encoding"}},
+		{[]string{"Origin", "Origin"}, []string{"Origin, Accept-Encoding"}},
+		{[]string{"*"}, []string{"*"}},
+	} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header()["Vary"] = tc.vary
+			io.WriteString(w, testBody)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tc.expect, resp.Result().Header["Vary"], "%+v", tc)
+	}
+}
+
+func TestGzipHandlerVaryNoGzip(t *testing.T) {
+	for _, acceptEncoding := range []string{"", "identity"} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Vary", "Origin")
+			io.WriteString(w, testBody)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, "", res.Header.Get("Content-Encoding"), "accept-encoding %q", acceptEncoding)
+		assert.Equal(t, []string{"Origin, Accept-Encoding"}, res.Header["Vary"], "accept-encoding %q", acceptEncoding)
+		assert.Equal(t, testBody, resp.Body.String(), "accept-encoding %q", acceptEncoding)
+	}
+}
+
+func TestGzipHandlerHijackServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	for _, acceptEncoding := range []string{"", "gzip"} {
+		var errLog bytes.Buffer
+		done := make(chan struct{})
+
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if !assert.NoError(t, err, "accept-encoding %q", acceptEncoding) {
+				return
+			}
+			defer conn.Close()
+
+			rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 4\r\nConnection: close\r\n\r\ntest")
+			rw.Flush()
+		}))
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(done)
+			handler.ServeHTTP(w, r)
+		}))
+		srv.Config.ErrorLog = log.New(&errLog, "", 0)
+		srv.Start()
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err, "Unexpected error making http request")
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		res, err := client.Do(req)
+		require.NoError(t, err, "Unexpected error making http request")
+
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err, "Unexpected error reading response body")
+		res.Body.Close()
+
+		<-done
+		srv.Close()
+
+		assert.Equal(t, "test", string(body), "accept-encoding %q", acceptEncoding)
+		assert.Equal(t, "", errLog.String(), "accept-encoding %q", acceptEncoding)
+	}
+}
+
+func TestStrictNegotiation(t *testing.T) {
+	custom := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotAcceptable)
+		io.WriteString(w, "custom")
+	})
+
+	for _, tc := range []struct {
+		name           string
+		accept         string
+		opts           []Option
+		expectCode     int
+		expectEncoding string
+		expectBody     string
+	}{
+		{"lenient", "*;q=0", nil, http.StatusOK, "", testBody},
+		{"strict", "gzip;q=0, identity;q=0", []Option{StrictNegotiation(nil)}, http.StatusNotAcceptable, "", ""},
+		{"strict-star", "*;q=0", []Option{StrictNegotiation(nil)}, http.StatusNotAcceptable, "", ""},
+		{"strict-custom", "*;q=0", []Option{StrictNegotiation(custom)}, http.StatusNotAcceptable, "", "custom"},
+		{"strict-gzip", "gzip, identity;q=0", []Option{StrictNegotiation(nil)}, http.StatusOK, "gzip", ""},
+		{"strict-identity", "gzip;q=0", []Option{StrictNegotiation(nil)}, http.StatusOK, "", testBody},
+	} {
+		handler := newTestHandler(testBody, tc.opts...)
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", tc.accept)
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, tc.expectCode, res.StatusCode, tc.name)
+		assert.Equal(t, tc.expectEncoding, res.Header.Get("Content-Encoding"), tc.name)
+		assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"), tc.name)
+
+		switch {
+		case tc.expectCode == http.StatusNotAcceptable && tc.expectBody == "":
+			assert.Contains(t, resp.Body.String(), "gzip", tc.name)
+		case tc.expectEncoding == "":
+			assert.Equal(t, tc.expectBody, resp.Body.String(), tc.name)
+		}
+	}
+}
+
+func TestStreamingContentTypes(t *testing.T) {
+	events := []string{"data: 1\n\n", "data: 2\r\n\r\n", "data: 3\n", "\n"}
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			io.WriteString(w, event)
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	var flushed []int
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(struct {
+		http.ResponseWriter
+		http.Flusher
+	}{
+		resp,
+		httpFlusherFunc(func() { flushed = append(flushed, resp.Body.Len()) }),
+	}, req)
+
+	assert.Equal(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+
+	var (
+		buf    bytes.Buffer
+		expect []int
+	)
+	gw, _ := gzip.NewWriterLevel(&buf, DefaultCompression)
+	for _, event := range events {
+		io.WriteString(gw, event)
+		// Every write but the third ends an event.
+		if event != "data: 3\n" {
+			gw.Flush()
+			expect = append(expect, buf.Len())
+		}
+	}
+	gw.Close()
+
+	assert.Equal(t, expect, flushed)
+
+	assert.Equal(t, buf.Bytes(), resp.Body.Bytes())
+}
+
+func TestStreamingContentTypesFlush(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.True(t, resp.Flushed, "Flush did not call underlying http.Flusher")
+	assert.Equal(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+}
+
+func TestStreamingContentTypesDisabled(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+	}), StreamingContentTypes(nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.False(t, resp.Flushed, "Flush called underlying http.Flusher before MinSize was reached")
+	assert.Equal(t, "", resp.Result().Header.Get("Content-Encoding"))
+	assert.Equal(t, "data: 1\n\n", resp.Body.String())
+}
+
+func TestScanEvents(t *testing.T) {
+	for _, tc := range []struct {
+		writes []string
+		expect []bool
+	}{
+		{[]string{"data: 1\n\n"}, []bool{true}},
+		{[]string{"data: 1\r\n\r\n"}, []bool{true}},
+		{[]string{"data: 1\r\r"}, []bool{true}},
+		{[]string{"data: 1\r\n"}, []bool{false}},
+		{[]string{"data: 1\n", "\n"}, []bool{false, true}},
+		{[]string{"data: 1\r", "\n", "\r\n"}, []bool{false, false, true}},
+		{[]string{"data: 1\n", "data: 2\n"}, []bool{false, false}},
+		{[]string{"\n"}, []bool{false}},
+	} {
+		w := &responseWriter{}
+		for i, write := range tc.writes {
+			assert.Equal(t, tc.expect[i], w.scanEvents([]byte(write)), "%q: write %d", tc.writes, i)
+		}
+	}
+}
+
+func TestStreamingContentTypesServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	next := make(chan struct{})
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+
+			// Wait for the client to receive the event
+			// before sending the next.
+			select {
+			case <-next:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+	})))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err, "Unexpected error making http request")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err, "Unexpected error making http request")
+	defer res.Body.Close()
+
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return
+		}
+
+		br := bufio.NewReader(zr)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			lines <- line
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		for _, expect := range []string{fmt.Sprintf("data: %d\n", i), "\n"} {
+			select {
+			case line := <-lines:
+				require.Equal(t, expect, line)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for event %d", i)
+			}
+		}
+
+		next <- struct{}{}
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	flushed := make(chan int, 10)
+	var idle int
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+
+		select {
+		case <-flushed:
+		case <-time.After(5 * time.Second):
+			t.Error("timed out waiting for flush")
+			return
+		}
+
+		// Nothing has been written since the last flush,
+		// so the timer should not flush again.
+		time.Sleep(50 * time.Millisecond)
+		idle = len(flushed)
+
+		io.WriteString(w, testBody)
+	}), MinSize(0), FlushInterval(10*time.Millisecond))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(struct {
+		http.ResponseWriter
+		http.Flusher
+	}{
+		resp,
+		httpFlusherFunc(func() { flushed <- resp.Body.Len() }),
+	}, req)
+
+	assert.Equal(t, 0, idle, "timer flushed without any new data")
+	assert.Equal(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, testBody+testBody, string(body))
+}
+
+func TestFlushIntervalPanicsForInvalid(t *testing.T) {
+	assert.PanicsWithValue(t, "gziphandler: flush interval must not be negative", func() {
+		FlushInterval(-1)
+	}, "FlushInterval did not panic with negative interval")
+}
+
+func TestFlushIntervalServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	next := make(chan struct{})
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "{\"id\":%d,\"body\":%q}\n", i, testBody)
+
+			// Wait for the client to receive the line
+			// before sending the next.
+			select {
+			case <-next:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+	}), FlushInterval(10*time.Millisecond)))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err, "Unexpected error making http request")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err, "Unexpected error making http request")
+	defer res.Body.Close()
+
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return
+		}
+
+		br := bufio.NewReader(zr)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			lines <- line
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case line := <-lines:
+			require.Equal(t, fmt.Sprintf("{\"id\":%d,\"body\":%q}\n", i, testBody), line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for line %d", i)
+		}
+
+		next <- struct{}{}
+	}
+}
+
 // --------------------------------------------------------------------
 
 func BenchmarkGzipHandler_S2k(b *testing.B)   { benchmark(b, false, 2048) }
@@ -722,6 +1917,22 @@ func BenchmarkGzipHandler_P2k(b *testing.B)   { benchmark(b, true, 2048) }
 func BenchmarkGzipHandler_P20k(b *testing.B)  { benchmark(b, true, 20480) }
 func BenchmarkGzipHandler_P100k(b *testing.B) { benchmark(b, true, 102400) }
 
+func BenchmarkGzipHandler_Write(b *testing.B)       { benchmarkSmallWrites(b, false, 64, 0) }
+func BenchmarkGzipHandler_WriteString(b *testing.B) { benchmarkSmallWrites(b, true, 64, 0) }
+
+func BenchmarkGzipHandler_WriteBuffered(b *testing.B)       { benchmarkSmallWrites(b, false, 64, 4096) }
+func BenchmarkGzipHandler_WriteStringBuffered(b *testing.B) { benchmarkSmallWrites(b, true, 64, 4096) }
+
+func BenchmarkGzipHandler_WriteTiny(b *testing.B)         { benchmarkSmallWrites(b, false, 8, 0) }
+func BenchmarkGzipHandler_WriteStringTiny(b *testing.B)   { benchmarkSmallWrites(b, true, 8, 0) }
+func BenchmarkGzipHandler_WriteTinyBuffered(b *testing.B) { benchmarkSmallWrites(b, false, 8, 4096) }
+func BenchmarkGzipHandler_WriteStringTinyBuffered(b *testing.B) {
+	benchmarkSmallWrites(b, true, 8, 4096)
+}
+
+func BenchmarkGzipHandler_Large(b *testing.B)         { benchmarkLarge(b, false) }
+func BenchmarkGzipHandler_LargeParallel(b *testing.B) { benchmarkLarge(b, true) }
+
 // --------------------------------------------------------------------
 
 func gzipStrLevel(s string, lvl int) []byte {
@@ -755,6 +1966,77 @@ func benchmark(b *testing.B, parallel bool, size int) {
 	}
 }
 
+func benchmarkSmallWrites(b *testing.B, writeString bool, fragmentSize, bufferSize int) {
+	bin, err := ioutil.ReadFile("testdata/benchmark.json")
+	require.NoError(b, err)
+
+	// Split the body into many small fragments as
+	// templating and JSON encoding code would.
+	var fragments []string
+	for body := string(bin[:20480]); len(body) != 0; {
+		n := fragmentSize
+		if n > len(body) {
+			n = len(body)
+		}
+
+		fragments = append(fragments, body[:n])
+		body = body[n:]
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, fragment := range fragments {
+			if writeString {
+				io.WriteString(w, fragment)
+			} else {
+				w.Write([]byte(fragment))
+			}
+		}
+	}), WriteBufferSize(bufferSize))
+
+	b.SetBytes(20480)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runBenchmark(b, req, handler)
+	}
+}
+
+func benchmarkLarge(b *testing.B, parallelCompression bool) {
+	bin, err := ioutil.ReadFile("testdata/benchmark.json")
+	require.NoError(b, err)
+
+	body := bytes.Repeat(bin, (16<<20)/len(bin)+1)[:16<<20]
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	var opts []Option
+	if parallelCompression {
+		opts = append(opts, ParallelCompression(0, 0, 0))
+	}
+
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}), opts...)
+
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(discardResponseWriter{make(http.Header)}, req)
+	}
+}
+
+// discardResponseWriter is an http.ResponseWriter that
+// discards the response.
+type discardResponseWriter struct {
+	h http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header         { return w.h }
+func (w discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardResponseWriter) WriteHeader(int)             {}
+
 func runBenchmark(b *testing.B, req *http.Request, handler http.Handler) {
 	res := httptest.NewRecorder()
 	handler.ServeHTTP(res, req)
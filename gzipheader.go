@@ -0,0 +1,70 @@
+package gziphandler
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// InferGzipHeader sets the metadata in the gzip header of
+// a response from its HTTP header, for use with
+// GzipHeader.
+//
+// Name is set from the filename parameter of the
+// Content-Disposition header, without any directory, and
+// ModTime is set from the Last-Modified header. This
+// matches what a client would use when saving the
+// uncompressed response to a file.
+func InferGzipHeader(r *http.Request, h http.Header, gh *gzip.Header) {
+	if cd := h.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil {
+			gh.Name = baseName(params["filename"])
+		}
+	}
+
+	if lm := h.Get("Last-Modified"); lm != "" {
+		if t, err := http.ParseTime(lm); err == nil {
+			gh.ModTime = t
+		}
+	}
+}
+
+// baseName returns the last element of a file name which
+// may use either / or \ as a separator.
+func baseName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	switch name {
+	case ".", "..":
+		return ""
+	default:
+		return name
+	}
+}
+
+// sanitizeGzipHeader removes the Name and Comment from gh
+// if they can't be encoded, rather than failing the
+// response. The gzip header can only store Latin-1
+// strings without a NUL character.
+func sanitizeGzipHeader(gh *gzip.Header) {
+	if !isLatin1(gh.Name) {
+		gh.Name = ""
+	}
+
+	if !isLatin1(gh.Comment) {
+		gh.Comment = ""
+	}
+}
+
+func isLatin1(s string) bool {
+	for _, r := range s {
+		if r == 0 || r > 0xff {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,144 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveGzipHeader serves handler and returns the gzip
+// header of the response.
+func serveGzipHeader(t *testing.T, handler http.Handler) gzip.Header {
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	require.Equal(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, testBody, string(body))
+
+	return zr.Header
+}
+
+func TestGzipHeader(t *testing.T) {
+	modTime := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Name", "test.txt")
+		io.WriteString(w, testBody)
+	}), GzipHeader(func(r *http.Request, h http.Header, gh *gzip.Header) {
+		gh.Name = h.Get("X-Name")
+		gh.Comment = r.URL.Path
+		gh.ModTime = modTime
+		gh.OS = 3
+	}))
+
+	gh := serveGzipHeader(t, handler)
+	assert.Equal(t, "test.txt", gh.Name)
+	assert.Equal(t, "/whatever", gh.Comment)
+	assert.True(t, modTime.Equal(gh.ModTime), "unexpected ModTime %v", gh.ModTime)
+	assert.Equal(t, byte(3), gh.OS)
+
+	// The metadata must not leak to other responses
+	// through the gzip.Writer pool.
+	gh = serveGzipHeader(t, newTestHandler(testBody))
+	assert.Equal(t, gzip.Header{OS: 255}, gh)
+}
+
+func TestGzipHeaderInvalid(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}), GzipHeader(func(r *http.Request, h http.Header, gh *gzip.Header) {
+		gh.Name = "日本.txt"
+		gh.Comment = "a\x00b"
+	}))
+
+	gh := serveGzipHeader(t, handler)
+	assert.Equal(t, "", gh.Name)
+	assert.Equal(t, "", gh.Comment)
+}
+
+func TestInferGzipHeader(t *testing.T) {
+	modTime := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	for _, tc := range []struct {
+		disposition  string
+		lastModified string
+
+		expectName    string
+		expectModTime time.Time
+	}{
+		{"", "", "", time.Time{}},
+		{`attachment; filename="report.csv"`, "", "report.csv", time.Time{}},
+		{`attachment; filename=report.csv`, modTime.Format(http.TimeFormat), "report.csv", modTime},
+		{`attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.txt`, "", "résumé.txt", time.Time{}},
+		{`attachment; filename="../../etc/passwd"`, "", "passwd", time.Time{}},
+		{`attachment; filename="C:\\files\\report.csv"`, "", "report.csv", time.Time{}},
+		{`attachment; filename=".."`, "", "", time.Time{}},
+		{`inline`, "", "", time.Time{}},
+		{`attachment; filename="report.csv`, "", "", time.Time{}},
+		{"", "invalid", "", time.Time{}},
+	} {
+		h := make(http.Header)
+		if tc.disposition != "" {
+			h.Set("Content-Disposition", tc.disposition)
+		}
+		if tc.lastModified != "" {
+			h.Set("Last-Modified", tc.lastModified)
+		}
+
+		var gh gzip.Header
+		InferGzipHeader(nil, h, &gh)
+
+		assert.Equal(t, tc.expectName, gh.Name, "%q", tc.disposition)
+		assert.True(t, tc.expectModTime.Equal(gh.ModTime), "%q: unexpected ModTime %v", tc.lastModified, gh.ModTime)
+	}
+}
+
+func TestInferGzipHeaderServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	modTime := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+		http.ServeContent(w, r, "", modTime, bytes.NewReader([]byte(testBody)))
+	}), GzipHeader(InferGzipHeader)))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err, "Unexpected error making http request")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err, "Unexpected error making http request")
+	defer res.Body.Close()
+
+	zr, err := gzip.NewReader(res.Body)
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+
+	assert.Equal(t, testBody, string(body))
+	assert.Equal(t, "report.csv", zr.Header.Name)
+	assert.True(t, modTime.Equal(zr.Header.ModTime), "unexpected ModTime %v", zr.Header.ModTime)
+}
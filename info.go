@@ -0,0 +1,92 @@
+package gziphandler
+
+import (
+	"net/http"
+	"time"
+)
+
+// responseInfo records how a response was written. It is
+// only collected if an Option needs it, see
+// config.observer.
+type responseInfo struct {
+	// The content coding of the response, identity if it
+	// has none.
+	encoding string
+
+	// The gzip compression level, if encoding is gzip.
+	level int
+
+	// Why the response wasn't compressed with gzip, if it
+	// wasn't.
+	reason string
+
+	// The content coding that was decoded, see Transcode.
+	transcoded string
+
+	// The number of uncompressed bytes written by the
+	// handler and the number of bytes written to the
+	// underlying http.ResponseWriter.
+	in, out int64
+
+	// The time spent in the gzip writer, excluding the
+	// time spent writing to the underlying
+	// http.ResponseWriter.
+	encodeTime time.Duration
+
+	// The first error that occurred while writing the
+	// response.
+	err error
+}
+
+// Skip reasons for responseInfo.reason.
+const (
+	reasonNotAccepted   = "not-accepted"
+	reasonNotAcceptable = "not-acceptable"
+	reasonEncoded       = "encoded"
+	reasonContentType   = "content-type"
+	reasonMinSize       = "min-size"
+	reasonMinSaving     = "min-saving"
+)
+
+// skip records reason as the reason the response wasn't
+// compressed and returns true.
+func (w *responseWriter) skip(reason string) bool {
+	if w.info != nil {
+		w.info.reason = reason
+	}
+
+	return true
+}
+
+// count records that n uncompressed bytes were written by
+// the handler.
+func (w *responseWriter) count(n int) {
+	if w.info != nil {
+		w.info.in += int64(n)
+	}
+}
+
+// now returns the current time if info is being collected.
+func (w *responseWriter) now() time.Time {
+	if w.info == nil {
+		return time.Time{}
+	}
+
+	return time.Now()
+}
+
+// addEncodeTime adds the time since start to encodeTime.
+// start must have been returned by now.
+func (w *responseWriter) addEncodeTime(start time.Time) {
+	if w.info != nil {
+		w.info.encodeTime += time.Since(start)
+	}
+}
+
+// observe calls the observer, if any, with info once the
+// response has been closed.
+func (h *handler) observe(r *http.Request, info *responseInfo) {
+	if h.observer != nil && info != nil {
+		h.observer(r, info)
+	}
+}
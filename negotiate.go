@@ -0,0 +1,72 @@
+package gziphandler
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// identityAcceptable reports whether the identity content
+// coding is acceptable to the client according to the
+// Accept-Encoding header of h. See RFC 9110, section
+// 12.5.3.
+//
+// identity is acceptable unless it is explicitly given a
+// qvalue of zero, or * is given a qvalue of zero and
+// identity is not listed.
+func identityAcceptable(h http.Header) bool {
+	identity, star := -1.0, -1.0
+	for _, line := range h["Accept-Encoding"] {
+		for _, part := range strings.Split(line, ",") {
+			coding, q := parseCoding(part)
+			switch {
+			case strings.EqualFold(coding, "identity"):
+				identity = q
+			case coding == "*":
+				star = q
+			}
+		}
+	}
+
+	switch {
+	case identity >= 0:
+		return identity > 0
+	case star >= 0:
+		return star > 0
+	default:
+		return true
+	}
+}
+
+// parseCoding parses an element of the Accept-Encoding
+// header and returns the content coding and its qvalue. An
+// invalid or missing qvalue is treated as 1.
+func parseCoding(s string) (coding string, q float64) {
+	params := strings.Split(s, ";")
+	coding, q = strings.TrimSpace(params[0]), 1
+
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if len(param) < 2 || (param[0] != 'q' && param[0] != 'Q') || param[1] != '=' {
+			continue
+		}
+
+		if v, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64); err == nil && v >= 0 && v <= 1 {
+			q = v
+		}
+	}
+
+	return coding, q
+}
+
+// notAcceptableHandler is the default handler used by
+// StrictNegotiation.
+func notAcceptableHandler(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	w.WriteHeader(http.StatusNotAcceptable)
+	io.WriteString(w, "406 Not Acceptable: supported content codings are gzip and identity\n")
+}
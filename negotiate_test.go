@@ -0,0 +1,36 @@
+package gziphandler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityAcceptable(t *testing.T) {
+	for _, tc := range []struct {
+		accept []string
+		expect bool
+	}{
+		{nil, true},
+		{[]string{""}, true},
+		{[]string{"gzip"}, true},
+		{[]string{"gzip;q=0"}, true},
+		{[]string{"identity"}, true},
+		{[]string{"identity;q=0"}, false},
+		{[]string{"identity; Q=0.0"}, false},
+		{[]string{"IDENTITY;q=0"}, false},
+		{[]string{"gzip;q=0, identity;q=0"}, false},
+		{[]string{"*;q=0"}, false},
+		{[]string{"*"}, true},
+		{[]string{"*;q=0, identity"}, true},
+		{[]string{"*;q=0, identity;q=0.5"}, true},
+		{[]string{"identity;q=0, *"}, false},
+		{[]string{"gzip", "*;q=0"}, false},
+		{[]string{"identity;q=invalid"}, true},
+		{[]string{"identity;q=2"}, true},
+	} {
+		h := http.Header{"Accept-Encoding": tc.accept}
+		assert.Equal(t, tc.expect, identityAcceptable(h), "%q", tc.accept)
+	}
+}
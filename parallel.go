@@ -0,0 +1,315 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// encoder is the interface shared by *gzip.Writer and
+// *parallelWriter.
+type encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// encoder returns the writer that compresses the response.
+func (w *responseWriter) encoder() encoder {
+	if w.pw != nil {
+		return w.pw
+	}
+
+	return w.gw
+}
+
+var flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+func flateWriterGet(w io.Writer, level int) *flate.Writer {
+	if fw, ok := flateWriterPools[level-flate.HuffmanOnly].Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return fw
+	}
+
+	fw, _ := flate.NewWriter(w, level)
+	return fw
+}
+
+func flateWriterPut(fw *flate.Writer, level int) {
+	flateWriterPools[level-flate.HuffmanOnly].Put(fw)
+}
+
+var parallelBlockPool = &sync.Pool{
+	New: func() interface{} {
+		return new(parallelBlock)
+	},
+}
+
+// parallelBlock is a block of the response that is
+// compressed in its own goroutine.
+type parallelBlock struct {
+	in  []byte
+	out bytes.Buffer
+
+	// done is closed once out has been written.
+	done chan struct{}
+}
+
+func (blk *parallelBlock) compress(level int) {
+	defer close(blk.done)
+
+	blk.out.Reset()
+
+	// Flushing, rather than closing, the flate.Writer
+	// ends the block on a byte boundary without marking it
+	// as the final block, so the blocks can be
+	// concatenated.
+	fw := flateWriterGet(&blk.out, level)
+	fw.Write(blk.in)
+	fw.Flush()
+	flateWriterPut(fw, level)
+}
+
+// parallelWriter compresses a response with gzip, see
+// ParallelCompression.
+//
+// Until threshold bytes have been written, the response is
+// compressed by gw. gw is then flushed, which ends the
+// deflate stream on a byte boundary, and the rest of the
+// response is split into blocks that are compressed
+// concurrently and written in order. As each block is
+// compressed without the preceding data as a dictionary,
+// the output is slightly larger than that of gw.
+//
+// The result is a standard single member gzip stream. The
+// header is written by gw and the footer, which has the
+// CRC-32 and size of the entire response, by Close.
+type parallelWriter struct {
+	gw *gzip.Writer
+	w  io.Writer
+
+	level       int
+	threshold   int64
+	blockSize   int
+	concurrency int
+
+	// The number of bytes written and their CRC-32.
+	n   int64
+	crc uint32
+
+	// Whether gw has been flushed and we are compressing
+	// in blocks.
+	parallel bool
+
+	// block is the block being filled and queue has the
+	// blocks being compressed, in order.
+	block *parallelBlock
+	queue []*parallelBlock
+
+	err error
+}
+
+func newParallelWriter(gw *gzip.Writer, w io.Writer, level int, c *parallelConfig) *parallelWriter {
+	return &parallelWriter{
+		gw: gw,
+		w:  w,
+
+		level:       level,
+		threshold:   c.threshold,
+		blockSize:   c.blockSize,
+		concurrency: c.concurrency,
+	}
+}
+
+func (p *parallelWriter) Write(b []byte) (n int, err error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+
+	if !p.parallel {
+		m := len(b)
+		if rem := p.threshold - p.n; int64(m) > rem {
+			m = int(rem)
+		}
+
+		n, err = p.gw.Write(b[:m])
+		p.update(b[:n])
+
+		if err != nil {
+			p.err = err
+			return n, err
+		}
+
+		if b = b[m:]; len(b) == 0 {
+			return n, nil
+		}
+
+		if err := p.gw.Flush(); err != nil {
+			p.err = err
+			return n, err
+		}
+
+		p.parallel = true
+	}
+
+	for len(b) != 0 {
+		if p.block == nil {
+			p.block = p.newBlock()
+		}
+
+		blk := p.block
+		m := copy(blk.in[len(blk.in):p.blockSize], b)
+		blk.in = blk.in[:len(blk.in)+m]
+
+		p.update(b[:m])
+		n += m
+		b = b[m:]
+
+		if len(blk.in) == p.blockSize {
+			if err := p.dispatch(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// update records that b has been written.
+func (p *parallelWriter) update(b []byte) {
+	p.n += int64(len(b))
+	p.crc = crc32.Update(p.crc, crc32.IEEETable, b)
+}
+
+func (p *parallelWriter) newBlock() *parallelBlock {
+	blk := parallelBlockPool.Get().(*parallelBlock)
+	if cap(blk.in) < p.blockSize {
+		blk.in = make([]byte, 0, p.blockSize)
+	}
+
+	blk.in = blk.in[:0]
+	return blk
+}
+
+// dispatch starts compressing the current block. If there
+// are already concurrency blocks being compressed, it
+// first waits for the oldest and writes it.
+func (p *parallelWriter) dispatch() error {
+	if len(p.queue) == p.concurrency {
+		if err := p.writeOldest(); err != nil {
+			return err
+		}
+	}
+
+	blk := p.block
+	p.block = nil
+
+	blk.done = make(chan struct{})
+	p.queue = append(p.queue, blk)
+
+	go blk.compress(p.level)
+	return nil
+}
+
+// writeOldest waits for the oldest block to be compressed
+// and writes it.
+func (p *parallelWriter) writeOldest() error {
+	blk := p.queue[0]
+	<-blk.done
+
+	n := copy(p.queue, p.queue[1:])
+	p.queue[n] = nil
+	p.queue = p.queue[:n]
+
+	_, err := p.w.Write(blk.out.Bytes())
+	parallelBlockPool.Put(blk)
+
+	if err != nil {
+		p.err = err
+	}
+
+	return err
+}
+
+// Flush compresses and writes any buffered data.
+func (p *parallelWriter) Flush() error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if !p.parallel {
+		if err := p.gw.Flush(); err != nil {
+			p.err = err
+			return err
+		}
+
+		return nil
+	}
+
+	if p.block != nil && len(p.block.in) != 0 {
+		if err := p.dispatch(); err != nil {
+			return err
+		}
+	}
+
+	for len(p.queue) != 0 {
+		if err := p.writeOldest(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close flushes any buffered data and writes the final
+// block and the gzip footer. It does not close the
+// underlying io.Writer.
+func (p *parallelWriter) Close() error {
+	// Wait for any blocks still being compressed if we
+	// stopped early.
+	defer p.release()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	if !p.parallel {
+		p.err = p.gw.Close()
+		return p.err
+	}
+
+	if err := p.Flush(); err != nil {
+		return err
+	}
+
+	// An empty final block with fixed Huffman codes, then
+	// the CRC-32 and size. See RFC 1951, section 3.2.6,
+	// and RFC 1952, section 2.3.1.
+	var footer [10]byte
+	footer[0], footer[1] = 0x03, 0x00
+	binary.LittleEndian.PutUint32(footer[2:], p.crc)
+	binary.LittleEndian.PutUint32(footer[6:], uint32(p.n))
+
+	_, p.err = p.w.Write(footer[:])
+	return p.err
+}
+
+// release waits for any blocks being compressed and
+// returns them to the pool.
+func (p *parallelWriter) release() {
+	for _, blk := range p.queue {
+		<-blk.done
+		parallelBlockPool.Put(blk)
+	}
+
+	p.queue = nil
+
+	if p.block != nil {
+		parallelBlockPool.Put(p.block)
+		p.block = nil
+	}
+}
@@ -0,0 +1,247 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newParallelTestBody returns a compressible body of n
+// bytes.
+func newParallelTestBody(n int) []byte {
+	rng := rand.New(rand.NewSource(1))
+
+	words := []string{"gzip ", "handler ", "parallel ", "block ", "compression ", "\n"}
+
+	body := make([]byte, 0, n+16)
+	for len(body) < n {
+		body = append(body, words[rng.Intn(len(words))]...)
+	}
+
+	return body[:n]
+}
+
+// gunzipSingle decodes a gzip stream and checks that it
+// has only a single member.
+func gunzipSingle(t *testing.T, b []byte) ([]byte, gzip.Header) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	zr.Multistream(false)
+
+	body, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+
+	_, err = zr.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	// There must be no more members.
+	gh := zr.Header
+	assert.Equal(t, io.EOF, zr.Reset(bytes.NewReader(nil)))
+
+	return body, gh
+}
+
+func TestParallelWriter(t *testing.T) {
+	body := newParallelTestBody(1 << 20)
+
+	for _, tc := range []struct {
+		name        string
+		size        int
+		threshold   int64
+		blockSize   int
+		concurrency int
+		level       int
+		flush       bool
+	}{
+		{"below-threshold", 1 << 10, 1 << 12, 1 << 12, 2, DefaultCompression, false},
+		{"at-threshold", 1 << 12, 1 << 12, 1 << 12, 2, DefaultCompression, false},
+		{"no-threshold", 1 << 20, 0, 1 << 14, 4, DefaultCompression, false},
+		{"threshold", 1 << 20, 100000, 1 << 14, 4, DefaultCompression, false},
+		{"partial-block", 100000, 1000, 1 << 14, 4, DefaultCompression, false},
+		{"concurrency-1", 1 << 18, 0, 1 << 12, 1, DefaultCompression, false},
+		{"flush", 1 << 20, 1 << 12, 1 << 14, 4, DefaultCompression, true},
+		{"best-speed", 1 << 20, 0, 1 << 16, 4, BestSpeed, false},
+		{"best-compression", 1 << 18, 0, 1 << 16, 4, BestCompression, false},
+		{"huffman-only", 1 << 18, 0, 1 << 16, 4, HuffmanOnly, false},
+		{"no-compression", 1 << 18, 0, 1 << 16, 4, NoCompression, false},
+	} {
+		var buf bytes.Buffer
+
+		gw := gzipWriterGet(&buf, tc.level)
+		gw.Header.Name = tc.name
+
+		pw := newParallelWriter(gw, &buf, tc.level, &parallelConfig{
+			threshold:   tc.threshold,
+			blockSize:   tc.blockSize,
+			concurrency: tc.concurrency,
+		})
+
+		// Write in pieces of varying size that don't line
+		// up with the blocks.
+		rng := rand.New(rand.NewSource(2))
+		for b := body[:tc.size]; len(b) != 0; {
+			n := rng.Intn(10000) + 1
+			if n > len(b) {
+				n = len(b)
+			}
+
+			nn, err := pw.Write(b[:n])
+			require.NoError(t, err, tc.name)
+			require.Equal(t, n, nn, tc.name)
+
+			assert.True(t, len(pw.queue) <= tc.concurrency, "%s: %d blocks being compressed", tc.name, len(pw.queue))
+
+			if tc.flush && rng.Intn(10) == 0 {
+				require.NoError(t, pw.Flush(), tc.name)
+				assert.Empty(t, pw.queue, tc.name)
+			}
+
+			b = b[n:]
+		}
+
+		require.NoError(t, pw.Close(), tc.name)
+		gzipWriterPut(gw, tc.level)
+
+		assert.Equal(t, int64(tc.size) > tc.threshold, pw.parallel, tc.name)
+
+		got, gh := gunzipSingle(t, buf.Bytes())
+		assert.True(t, bytes.Equal(body[:tc.size], got), "%s: unexpected body", tc.name)
+		assert.Equal(t, tc.name, gh.Name, tc.name)
+	}
+}
+
+func TestParallelWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+
+	gw := gzipWriterGet(&buf, DefaultCompression)
+	defer gzipWriterPut(gw, DefaultCompression)
+
+	pw := newParallelWriter(gw, &buf, DefaultCompression, &parallelConfig{
+		blockSize:   1 << 12,
+		concurrency: 2,
+	})
+	require.NoError(t, pw.Close())
+
+	got, _ := gunzipSingle(t, buf.Bytes())
+	assert.Empty(t, got)
+}
+
+// limitedWriter fails once n bytes have been written.
+type limitedWriter struct {
+	n int
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if len(b) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errTestWrite
+	}
+
+	w.n -= len(b)
+	return len(b), nil
+}
+
+func TestParallelWriterError(t *testing.T) {
+	body := newParallelTestBody(1 << 20)
+
+	for _, limit := range []int{0, 100, 10000, 100000} {
+		w := &limitedWriter{n: limit}
+
+		gw := gzipWriterGet(w, DefaultCompression)
+		pw := newParallelWriter(gw, w, DefaultCompression, &parallelConfig{
+			threshold:   1 << 12,
+			blockSize:   1 << 12,
+			concurrency: 4,
+		})
+
+		var err error
+		for b := body; len(b) != 0 && err == nil; b = b[1000:] {
+			_, err = pw.Write(b[:1000])
+		}
+
+		assert.True(t, errors.Is(err, errTestWrite), "limit %d: unexpected error %v", limit, err)
+		assert.True(t, errors.Is(pw.Close(), errTestWrite), "limit %d", limit)
+		assert.Empty(t, pw.queue, "limit %d", limit)
+
+		gzipWriterPut(gw, DefaultCompression)
+	}
+}
+
+func TestParallelCompression(t *testing.T) {
+	body := newParallelTestBody(1 << 20)
+
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+
+		for b := body; len(b) != 0; b = b[1<<12:] {
+			w.Write(b[:1<<12])
+		}
+	}), ParallelCompression(1<<16, 1<<16, 4), DebugHeaders(func(*http.Request) bool {
+		return true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	res := resp.Result()
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Regexp(t, `^gzip;level=6;in=1048576;out=\d+$`, res.Trailer.Get("X-Compression"))
+
+	got, _ := gunzipSingle(t, resp.Body.Bytes())
+	assert.True(t, bytes.Equal(body, got), "unexpected body")
+}
+
+func TestParallelCompressionServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	body := newParallelTestBody(1 << 20)
+
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		w.Write(body[len(body)/2:])
+	}), ParallelCompression(1<<16, 1<<16, 0)))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err, "Unexpected error making http request")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err, "Unexpected error making http request")
+
+	raw, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err, "Unexpected error reading response body")
+	res.Body.Close()
+
+	got, _ := gunzipSingle(t, raw)
+	assert.True(t, bytes.Equal(body, got), "unexpected body")
+}
+
+func TestParallelCompressionPanicsForInvalid(t *testing.T) {
+	assert.Panics(t, func() {
+		ParallelCompression(-1, 0, 0)
+	})
+	assert.Panics(t, func() {
+		ParallelCompression(0, -1, 0)
+	})
+	assert.Panics(t, func() {
+		ParallelCompression(0, 0, -1)
+	})
+}
@@ -0,0 +1,166 @@
+//go:build go1.21
+// +build go1.21
+
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type flushErrorRecorder struct {
+	*httptest.ResponseRecorder
+	flushed []int
+	err     error
+}
+
+func (w *flushErrorRecorder) FlushError() error {
+	w.flushed = append(w.flushed, w.Body.Len())
+	return w.err
+}
+
+func TestResponseControllerFlush(t *testing.T) {
+	var flushErr error
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+		flushErr = http.NewResponseController(w).Flush()
+		io.WriteString(w, testBody)
+	}), MinSize(0))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.NoError(t, flushErr)
+	assert.True(t, resp.Flushed, "Flush did not call underlying http.Flusher")
+	assert.Equal(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, testBody+testBody, string(body))
+}
+
+func TestResponseControllerFlushError(t *testing.T) {
+	var flushErr error
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+		flushErr = http.NewResponseController(w).Flush()
+	}), MinSize(0))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	errFlush := errors.New("flush failed")
+	resp := &flushErrorRecorder{
+		ResponseRecorder: httptest.NewRecorder(),
+		err:              errFlush,
+	}
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, errFlush, flushErr)
+	require.Len(t, resp.flushed, 1)
+
+	// The gzip.Writer must have been flushed before the
+	// underlying http.ResponseWriter.
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, DefaultCompression)
+	io.WriteString(gw, testBody)
+	gw.Flush()
+	assert.Equal(t, buf.Len(), resp.flushed[0])
+}
+
+func TestResponseControllerFlushBuffered(t *testing.T) {
+	var flushErr error
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "test")
+		flushErr = http.NewResponseController(w).Flush()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := &flushErrorRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(resp, req)
+
+	assert.NoError(t, flushErr)
+	assert.Empty(t, resp.flushed, "Flush called underlying http.ResponseWriter before MinSize was reached")
+	assert.Equal(t, "test", resp.Body.String())
+}
+
+func TestResponseControllerNotSupported(t *testing.T) {
+	var flushErr, deadlineErr error
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+
+		rc := http.NewResponseController(w)
+		flushErr = rc.Flush()
+		deadlineErr = rc.SetWriteDeadline(time.Now().Add(time.Minute))
+	}), MinSize(0))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	handler.ServeHTTP(struct{ http.ResponseWriter }{httptest.NewRecorder()}, req)
+
+	assert.True(t, errors.Is(flushErr, http.ErrNotSupported), "expected http.ErrNotSupported, got %v", flushErr)
+	assert.True(t, errors.Is(deadlineErr, http.ErrNotSupported), "expected http.ErrNotSupported, got %v", deadlineErr)
+}
+
+func TestResponseControllerServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	errs := make(chan error, 4)
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Make sure the optional interface wrappers
+		// also support Unwrap.
+		_, ok := w.(http.CloseNotifier)
+		assert.True(t, ok, "expected CloseNotifier")
+
+		rc := http.NewResponseController(w)
+		errs <- rc.SetReadDeadline(time.Now().Add(time.Minute))
+		errs <- rc.SetWriteDeadline(time.Now().Add(time.Minute))
+		errs <- rc.EnableFullDuplex()
+
+		io.WriteString(w, testBody)
+		errs <- rc.Flush()
+	})))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err, "Unexpected error making http request")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err, "Unexpected error making http request")
+	defer res.Body.Close()
+
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+
+	zr, err := gzip.NewReader(res.Body)
+	require.NoError(t, err, "Unexpected error reading response body")
+
+	body, err := ioutil.ReadAll(zr)
+	require.NoError(t, err, "Unexpected error reading response body")
+	assert.Equal(t, testBody, string(body))
+
+	for i := 0; i < cap(errs); i++ {
+		assert.NoError(t, <-errs)
+	}
+}
@@ -0,0 +1,138 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTrailerHandler returns a handler that writes body and
+// then sets the X-Checksum trailer. If prefix is true, the
+// trailer is set with http.TrailerPrefix rather than being
+// declared. If flush is true, the response is flushed
+// before the trailer is set.
+func newTrailerHandler(body string, prefix, flush bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if !prefix {
+			w.Header().Set("Trailer", "X-Checksum")
+		}
+
+		io.WriteString(w, body)
+
+		if flush {
+			w.(http.Flusher).Flush()
+		}
+
+		if prefix {
+			w.Header().Set(http.TrailerPrefix+"X-Checksum", "abc")
+		} else {
+			w.Header().Set("X-Checksum", "abc")
+		}
+	})
+}
+
+var trailerTests = []struct {
+	name           string
+	acceptEncoding string
+	body           string
+	prefix         bool
+	flush          bool
+
+	expectEncoding string
+}{
+	{"gzip", "gzip", testBody, false, false, "gzip"},
+	{"gzip-prefix", "gzip", testBody, true, false, "gzip"},
+	{"gzip-flush", "gzip", testBody, false, true, "gzip"},
+	{"small", "gzip", "test", false, false, ""},
+	{"small-prefix", "gzip", "test", true, false, ""},
+	{"small-flush", "gzip", "test", false, true, ""},
+	{"identity", "identity", testBody, false, false, ""},
+	{"identity-prefix", "identity", testBody, true, false, ""},
+}
+
+// readTrailerBody reads the body of a response, decoding
+// it if it was compressed with gzip.
+func readTrailerBody(t *testing.T, name string, encoding string, body io.Reader) string {
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(body)
+		require.NoError(t, err, name)
+
+		// The gzip footer must come before the trailers.
+		zr.Multistream(false)
+		body = zr
+	}
+
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err, name)
+	return string(b)
+}
+
+func TestTrailers(t *testing.T) {
+	for _, tc := range trailerTests {
+		handler := Gzip(newTrailerHandler(tc.body, tc.prefix, tc.flush))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, tc.expectEncoding, res.Header.Get("Content-Encoding"), tc.name)
+		assert.Equal(t, "", res.Header.Get("Content-Length"), tc.name)
+		assert.Equal(t, "", res.Header.Get("X-Checksum"), tc.name)
+		assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"), tc.name)
+
+		assert.Equal(t, tc.body, readTrailerBody(t, tc.name, tc.expectEncoding, res.Body), tc.name)
+	}
+}
+
+func TestTrailersServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	testTrailersServer(t, func(h http.Handler) *httptest.Server {
+		return httptest.NewServer(h)
+	}, 1)
+}
+
+// testTrailersServer tests that trailers set by the wrapped
+// handler are sent by a real server started with
+// newServer, after the gzip footer.
+func testTrailersServer(t *testing.T, newServer func(http.Handler) *httptest.Server, protoMajor int) {
+	for _, tc := range trailerTests {
+		srv := newServer(Gzip(newTrailerHandler(tc.body, tc.prefix, tc.flush)))
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err, "Unexpected error making http request")
+		req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err, "Unexpected error making http request")
+
+		raw, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err, "Unexpected error reading response body")
+		res.Body.Close()
+		srv.Close()
+
+		assert.Equal(t, protoMajor, res.ProtoMajor, tc.name)
+		assert.Equal(t, tc.expectEncoding, res.Header.Get("Content-Encoding"), tc.name)
+		assert.Equal(t, "", res.Header.Get("X-Checksum"), tc.name)
+		assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"), tc.name)
+
+		if protoMajor == 1 {
+			assert.Equal(t, []string{"chunked"}, res.TransferEncoding, tc.name)
+		}
+
+		assert.Equal(t, tc.body, readTrailerBody(t, tc.name, tc.expectEncoding, bytes.NewReader(raw)), tc.name)
+	}
+}
@@ -0,0 +1,231 @@
+package gziphandler
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/tmthrgd/httputils"
+)
+
+// transcodeDecoder returns the Decoder for the content
+// coding of the response if it must be transcoded, or nil
+// if the response should be passed through as is.
+func (w *responseWriter) transcodeDecoder() Decoder {
+	// A partial response can't be decoded on its own.
+	if w.h.decoders == nil || w.code == http.StatusPartialContent {
+		return nil
+	}
+
+	// Multiple content codings are not supported.
+	ce := w.Header()["Content-Encoding"]
+	if len(ce) != 1 || strings.IndexByte(ce[0], ',') >= 0 {
+		return nil
+	}
+
+	coding := strings.ToLower(strings.TrimSpace(ce[0]))
+	switch {
+	case coding == "" || coding == "identity":
+		return nil
+	// We would have compressed the response with gzip
+	// anyway.
+	case (coding == "gzip" || coding == "x-gzip") && !w.noGzip:
+		return nil
+	case httputils.Negotiate(w.r.Header, "Accept-Encoding", coding) != "":
+		return nil
+	case coding == "x-gzip":
+		coding = "gzip"
+	}
+
+	return w.h.decoders[coding]
+}
+
+// startTranscode starts decoding the response and
+// switches to pass through mode. The decoded response is
+// written to a new responseWriter which will compress it
+// with gzip if the client accepts it.
+func (w *responseWriter) startTranscode(decode Decoder) (err error) {
+	h := w.Header()
+
+	if w.info != nil {
+		w.info.transcoded = strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding")))
+	}
+
+	h.Del("Content-Encoding")
+	h.Del("Content-Length")
+
+	// The decoded response is no longer byte-for-byte
+	// identical to the original.
+	if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("Etag", "W/"+etag)
+	}
+
+	inner := &responseWriter{
+		ResponseWriter: w.ResponseWriter,
+
+		h: w.h,
+		r: w.r,
+
+		buf: bufferPool.Get().(*[]byte),
+
+		code:   w.code,
+		noGzip: w.noGzip,
+
+		// The inner responseWriter writes the decoded
+		// response, so it records the sizes.
+		info:   w.info,
+		debug:  w.debug,
+		digest: w.digest,
+	}
+	w.debug, w.digest = false, nil
+
+	// The handler may modify the header once the decoder
+	// goroutine is running, so we must write the header
+	// now rather than waiting for minSize. Without a
+	// Content-Type, we can't stop net/http sniffing the
+	// compressed response, so we don't compress it.
+	if _, ok := h["Content-Type"]; !ok || inner.shouldPassThrough() {
+		err = inner.startPassThrough()
+	} else {
+		err = inner.startGzip()
+	}
+
+	w.tc = newTranscoder(inner, decode)
+
+	if buf := *w.buf; len(buf) != 0 && err == nil {
+		_, err = w.writePassThrough(buf)
+	}
+
+	w.releaseBuffer()
+	return err
+}
+
+// transcoder decodes the response in a separate goroutine
+// and writes it to an inner responseWriter.
+type transcoder struct {
+	pw *io.PipeWriter
+
+	// Guards inner against concurrent calls to
+	// FlushError.
+	mu    sync.Mutex
+	inner *responseWriter
+
+	// done is closed once the decoder goroutine has
+	// returned, after which err is set.
+	done chan struct{}
+	err  error
+}
+
+func newTranscoder(inner *responseWriter, decode Decoder) *transcoder {
+	pr, pw := io.Pipe()
+
+	t := &transcoder{
+		pw: pw,
+
+		inner: inner,
+
+		done: make(chan struct{}),
+	}
+	go t.run(pr, decode)
+
+	return t
+}
+
+func (t *transcoder) run(pr *io.PipeReader, decode Decoder) {
+	defer close(t.done)
+
+	t.err = t.decode(pr, decode)
+
+	// Unblock any calls to Write if we stopped before
+	// reaching the end of the response.
+	pr.CloseWithError(t.err)
+}
+
+func (t *transcoder) decode(r io.Reader, decode Decoder) error {
+	rc, err := decode(r)
+	if err == io.EOF {
+		// The response was empty, such as for a HEAD
+		// request.
+		return nil
+	} else if err != nil {
+		return &DecoderError{Err: err}
+	}
+	defer rc.Close()
+
+	bp := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bp)
+
+	for {
+		n, rerr := rc.Read(*bp)
+		if n > 0 {
+			t.mu.Lock()
+			_, werr := t.inner.Write((*bp)[:n])
+			t.mu.Unlock()
+
+			if werr != nil {
+				return werr
+			}
+		}
+
+		switch {
+		case rerr == io.EOF:
+			return nil
+		case rerr != nil:
+			return &DecoderError{Err: rerr}
+		}
+	}
+}
+
+// Header returns the header of the inner responseWriter.
+func (t *transcoder) Header() http.Header {
+	return t.inner.Header()
+}
+
+// Write writes encoded data to the decoder. It returns
+// once the decoder has read b, which may be before the
+// decoded data has been written.
+func (t *transcoder) Write(b []byte) (int, error) {
+	return t.pw.Write(b)
+}
+
+// WriteHeader is a no-op as the inner responseWriter has
+// already written the header.
+func (t *transcoder) WriteHeader(int) {}
+
+// FlushError flushes the inner responseWriter. Data that
+// the decoder has read but not yet decoded is not
+// flushed.
+func (t *transcoder) FlushError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.inner.FlushError()
+}
+
+// abort stops the decoder with err and releases the inner
+// responseWriter without writing anything more to it.
+func (t *transcoder) abort(err error) {
+	t.pw.CloseWithError(err)
+	<-t.done
+
+	t.inner.hijacked = true
+	t.inner.Close()
+}
+
+// Close waits for the decoder to finish and then closes
+// the inner responseWriter.
+func (t *transcoder) Close() error {
+	t.pw.Close()
+	<-t.done
+
+	t.inner.Close()
+
+	if t.err != nil {
+		return t.err
+	}
+
+	// Any error from writing the header or closing the
+	// inner responseWriter.
+	return t.inner.err
+}
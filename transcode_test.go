@@ -0,0 +1,269 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func zlibStr(s string) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	io.WriteString(zw, s)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestTranscode(t *testing.T) {
+	gzipBody := gzipStrLevel(testBody, DefaultCompression)
+	zlibBody := zlibStr(testBody)
+
+	for _, tc := range []struct {
+		name           string
+		acceptEncoding string
+		encoding       string
+		body           []byte
+
+		expectEncoding string
+		expectETag     string
+		expectBody     []byte
+	}{
+		{"gunzip", "", "gzip", gzipBody, "", `W/"abc"`, []byte(testBody)},
+		{"gunzip-br", "br", "gzip", gzipBody, "", `W/"abc"`, []byte(testBody)},
+		{"gunzip-x-gzip", "identity", "x-gzip", gzipBody, "", `W/"abc"`, []byte(testBody)},
+		{"gzip-accepted", "gzip", "gzip", gzipBody, "gzip", `"abc"`, gzipBody},
+		{"deflate-to-gzip", "gzip", "deflate", zlibBody, "gzip", `W/"abc"`, []byte(testBody)},
+		{"deflate-accepted", "gzip, deflate", "deflate", zlibBody, "deflate", `"abc"`, zlibBody},
+		{"unknown", "gzip", "br", []byte("test"), "br", `"abc"`, []byte("test")},
+		{"multiple", "", "deflate, gzip", []byte("test"), "deflate, gzip", `"abc"`, []byte("test")},
+	} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", tc.encoding)
+			w.Header().Set("Content-Length", strconv.Itoa(len(tc.body)))
+			w.Header().Set("Etag", `"abc"`)
+			w.Write(tc.body)
+		}), Transcode(nil))
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		res := resp.Result()
+		assert.Equal(t, tc.expectEncoding, res.Header.Get("Content-Encoding"), tc.name)
+		assert.Equal(t, tc.expectETag, res.Header.Get("Etag"), tc.name)
+		assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"), tc.name)
+
+		body := resp.Body.Bytes()
+		if tc.expectEncoding == "gzip" && tc.encoding != "gzip" {
+			assert.Equal(t, "", res.Header.Get("Content-Length"), tc.name)
+
+			zr, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err, tc.name)
+
+			body, err = ioutil.ReadAll(zr)
+			require.NoError(t, err, tc.name)
+		} else if tc.expectEncoding == "" {
+			assert.Equal(t, "", res.Header.Get("Content-Length"), tc.name)
+		}
+
+		assert.Equal(t, tc.expectBody, body, tc.name)
+	}
+}
+
+func TestTranscodeDecoders(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+		w.Write(zlibStr(testBody))
+	}), Transcode(map[string]Decoder{
+		"gzip": gzipDecoder,
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, "deflate", resp.Result().Header.Get("Content-Encoding"))
+	assert.Equal(t, zlibStr(testBody), resp.Body.Bytes())
+}
+
+func TestTranscodeEmpty(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusOK)
+	}), Transcode(nil))
+
+	req := httptest.NewRequest(http.MethodHead, "/whatever", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "", resp.Result().Header.Get("Content-Encoding"))
+	assert.Equal(t, 0, resp.Body.Len())
+}
+
+func TestTranscodeInvalid(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	resp := httptest.NewRecorder()
+
+	h := Gzip(nil, Transcode(nil)).(*handler)
+	gw := &responseWriter{
+		ResponseWriter: resp,
+
+		h: h,
+		r: req,
+
+		buf: bufferPool.Get().(*[]byte),
+
+		noGzip: true,
+	}
+
+	gw.Header().Set("Content-Encoding", "gzip")
+	io.WriteString(gw, testBody)
+
+	assert.Error(t, gw.Close())
+}
+
+func TestTranscodeReverseProxy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test: no external network in -short mode")
+	}
+
+	upstream := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, testBody)
+	}), ShouldGzip(func(*http.Request) ShouldGzipType {
+		return ForceGzip
+	})))
+	defer upstream.Close()
+
+	u, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(Gzip(httputil.NewSingleHostReverseProxy(u), Transcode(nil)))
+	defer srv.Close()
+
+	for _, acceptEncoding := range []string{"br", "gzip"} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err, "Unexpected error making http request")
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err, "Unexpected error making http request")
+
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err, "Unexpected error reading response body")
+		res.Body.Close()
+
+		if acceptEncoding == "gzip" {
+			assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+			assert.Equal(t, gzipStrLevel(testBody, DefaultCompression), body)
+		} else {
+			assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+			assert.Equal(t, testBody, string(body))
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	// Large enough to need multiple reads from the
+	// decoder.
+	plain := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(plain[:len(plain)/2])
+
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, DefaultCompression)
+	gw.Write(plain)
+	gw.Close()
+	compressed := buf.Bytes()
+
+	for _, tc := range []struct {
+		acceptEncoding string
+		expectEncoding string
+		expectBody     []byte
+	}{
+		{"", "", plain},
+		{"deflate", "", plain},
+		{"gzip", "gzip", compressed},
+	} {
+		handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Encoding", "gzip")
+
+			// Write in small pieces to make sure the body
+			// is decoded as it is written.
+			for b := compressed; len(b) != 0; {
+				n := 4096
+				if n > len(b) {
+					n = len(b)
+				}
+
+				_, err := w.Write(b[:n])
+				require.NoError(t, err)
+				b = b[n:]
+			}
+		}), Decompress())
+
+		req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tc.expectEncoding, resp.Result().Header.Get("Content-Encoding"), tc.acceptEncoding)
+		assert.True(t, bytes.Equal(tc.expectBody, resp.Body.Bytes()), "%s: unexpected response body", tc.acceptEncoding)
+	}
+}
+
+func TestDecompressOnlyGzip(t *testing.T) {
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+		w.Write(zlibStr(testBody))
+	}), Decompress())
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, "deflate", resp.Result().Header.Get("Content-Encoding"))
+	assert.Equal(t, zlibStr(testBody), resp.Body.Bytes())
+}
+
+func TestDecompressPartialContent(t *testing.T) {
+	compressed := gzipStrLevel(testBody, DefaultCompression)
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Range", "bytes 0-9/"+strconv.Itoa(len(compressed)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(compressed[:10])
+	}), Decompress())
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Range", "bytes=0-9")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+	assert.Equal(t, compressed[:10], resp.Body.Bytes())
+}
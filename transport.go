@@ -0,0 +1,397 @@
+package gziphandler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Decoder returns an io.ReadCloser that decodes the content
+// coding of r. Closing the io.ReadCloser must not close r.
+type Decoder func(r io.Reader) (io.ReadCloser, error)
+
+// DefaultDecoders returns the decoders used by Transport
+// when Decoders is nil. It supports the gzip and deflate
+// content codings.
+//
+// The returned map is a copy and may be modified, for
+// instance to add support for other content codings.
+func DefaultDecoders() map[string]Decoder {
+	return map[string]Decoder{
+		"gzip":    gzipDecoder,
+		"deflate": deflateDecoder,
+	}
+}
+
+func gzipDecoder(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// deflateDecoder decodes the deflate content coding. This
+// is meant to be zlib wrapped deflate data (RFC 1950), but
+// some servers send raw deflate data (RFC 1951) so we
+// accept either.
+func deflateDecoder(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	hdr, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// A zlib header has a compression method of 8
+	// (deflate) and is a multiple of 31. See RFC 1950,
+	// section 2.2.
+	if hdr[0]&0x0f == 8 && (uint(hdr[0])<<8|uint(hdr[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
+
+// Transport is an http.RoundTripper that transparently
+// compresses requests and decompresses responses.
+//
+// Transport advertises the content codings it can decode
+// in the Accept-Encoding header of each request and decodes
+// the response body accordingly. As with http.Transport,
+// if the request already has an Accept-Encoding header,
+// the response is returned unmodified.
+//
+// Transport is safe for concurrent use by multiple
+// goroutines.
+type Transport struct {
+	// Base is the http.RoundTripper used to make
+	// requests. If nil, http.DefaultTransport is used.
+	//
+	// If Base is an *http.Transport, it should not be
+	// relied upon to decompress responses.
+	Base http.RoundTripper
+
+	// Decoders maps content codings to the Decoder used
+	// to decode them. If nil, DefaultDecoders is used.
+	//
+	// The keys must be lower case. The x-gzip content
+	// coding is treated as gzip.
+	Decoders map[string]Decoder
+
+	// RequestMinSize is the minimum Content-Length of a
+	// request body before it will be compressed with
+	// gzip. Request bodies of unknown length and requests
+	// that already have a Content-Encoding header are not
+	// compressed.
+	//
+	// The server must support compressed request bodies.
+	// If zero, request bodies are not compressed.
+	RequestMinSize int64
+
+	// RequestCompressionLevel is the gzip compression
+	// level used to compress request bodies. See the
+	// level constants defined in this package.
+	//
+	// If zero, DefaultCompression is used.
+	RequestCompressionLevel int
+}
+
+// TransportStats records the sizes of the request and
+// response bodies of a request made with a Transport. See
+// WithTransportStats.
+//
+// The counts are updated as the bodies are read, so they
+// are only complete once the request body has been sent
+// and the response body has been read to EOF. They must
+// be read with atomic.LoadInt64 while the request is in
+// progress.
+type TransportStats struct {
+	// RequestBytes is the uncompressed size of the
+	// request body.
+	RequestBytes int64
+
+	// RequestWireBytes is the size of the request body
+	// as sent, after any compression.
+	RequestWireBytes int64
+
+	// ResponseBytes is the decoded size of the response
+	// body.
+	ResponseBytes int64
+
+	// ResponseWireBytes is the size of the response body
+	// as received, before any decoding.
+	ResponseWireBytes int64
+}
+
+type transportStatsKey struct{}
+
+// WithTransportStats returns a copy of ctx that will cause
+// Transport to record the sizes of the request and
+// response bodies in stats. It should be used with
+// http.Request.WithContext.
+func WithTransportStats(ctx context.Context, stats *TransportStats) context.Context {
+	return context.WithValue(ctx, transportStatsKey{}, stats)
+}
+
+var errInvalidRequestLevel = errors.New("gziphandler: invalid request compression level")
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	level := t.RequestCompressionLevel
+	if level == 0 {
+		level = DefaultCompression
+	}
+
+	if level < HuffmanOnly || level > BestCompression {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, errInvalidRequestLevel
+	}
+
+	stats, _ := req.Context().Value(transportStatsKey{}).(*TransportStats)
+
+	// The http.RoundTripper must not modify the request,
+	// so we make a shallow copy with its own header.
+	req2 := new(http.Request)
+	*req2 = *req
+	req2.Header = make(http.Header, len(req.Header)+2)
+	for k, v := range req.Header {
+		req2.Header[k] = v
+	}
+
+	if t.shouldCompressRequest(req) {
+		getBody := req.GetBody
+
+		req2.Body = compressBody(req.Body, level, stats)
+		req2.ContentLength = -1
+		req2.Header.Set("Content-Encoding", "gzip")
+		req2.Header.Del("Content-Length")
+
+		if getBody != nil {
+			req2.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+
+				return compressBody(body, level, stats), nil
+			}
+		}
+	} else if stats != nil && req.Body != nil && req.Body != http.NoBody {
+		req2.Body = countBody(countBody(req.Body, &stats.RequestBytes), &stats.RequestWireBytes)
+	}
+
+	decoders := t.Decoders
+	if decoders == nil {
+		decoders = DefaultDecoders()
+	}
+
+	// As with http.Transport, we don't ask for a
+	// compressed response if the request has a Range
+	// header as the range would apply to the compressed
+	// body.
+	decode := req.Header.Get("Accept-Encoding") == "" &&
+		req.Header.Get("Range") == "" && len(decoders) != 0
+	if decode {
+		req2.Header.Set("Accept-Encoding", acceptEncoding(decoders))
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	res, err := base.RoundTrip(req2)
+	if err != nil {
+		return nil, err
+	}
+
+	var codings []string
+	if decode && req.Method != http.MethodHead &&
+		res.Body != nil && res.Body != http.NoBody &&
+		bodyAllowedForStatus(res.StatusCode) {
+		codings = contentCodings(res.Header, decoders)
+	}
+
+	switch {
+	case len(codings) != 0:
+		if stats != nil {
+			res.Body = countBody(res.Body, &stats.ResponseWireBytes)
+		}
+
+		res.Body = &decodeReader{
+			body:     res.Body,
+			codings:  codings,
+			decoders: decoders,
+		}
+
+		if stats != nil {
+			res.Body = countBody(res.Body, &stats.ResponseBytes)
+		}
+
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Uncompressed = true
+	case stats != nil && res.Body != nil:
+		res.Body = countBody(countBody(res.Body, &stats.ResponseWireBytes), &stats.ResponseBytes)
+	}
+
+	return res, nil
+}
+
+func (t *Transport) shouldCompressRequest(req *http.Request) bool {
+	return t.RequestMinSize > 0 &&
+		req.Body != nil && req.Body != http.NoBody &&
+		req.ContentLength >= t.RequestMinSize &&
+		req.Header.Get("Content-Encoding") == ""
+}
+
+// acceptEncoding returns the value of the Accept-Encoding
+// header advertising the content codings of decoders.
+func acceptEncoding(decoders map[string]Decoder) string {
+	codings := make([]string, 0, len(decoders))
+	for coding := range decoders {
+		codings = append(codings, coding)
+	}
+
+	sort.Strings(codings)
+	return strings.Join(codings, ", ")
+}
+
+// contentCodings returns the content codings listed in the
+// Content-Encoding header of h, in the order they were
+// applied. If any of them are not supported by decoders,
+// contentCodings returns nil and the response is left
+// encoded.
+func contentCodings(h http.Header, decoders map[string]Decoder) []string {
+	var codings []string
+	for _, line := range h["Content-Encoding"] {
+		for _, coding := range strings.Split(line, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+
+			switch coding {
+			case "", "identity":
+				continue
+			case "x-gzip":
+				coding = "gzip"
+			}
+
+			if _, ok := decoders[coding]; !ok {
+				return nil
+			}
+
+			codings = append(codings, coding)
+		}
+	}
+
+	return codings
+}
+
+// compressBody returns an io.ReadCloser that reads body
+// compressed with gzip. body is compressed in a separate
+// goroutine and is closed once it has been read or the
+// returned io.ReadCloser is closed.
+func compressBody(body io.ReadCloser, level int, stats *TransportStats) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer body.Close()
+
+		var r io.Reader = body
+		if stats != nil {
+			r = countBody(body, &stats.RequestBytes)
+		}
+
+		gw := gzipWriterGet(pw, level)
+		defer gzipWriterPut(gw, level)
+
+		_, err := io.Copy(gw, r)
+		if cerr := gw.Close(); err == nil {
+			err = cerr
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	if stats != nil {
+		return countBody(pr, &stats.RequestWireBytes)
+	}
+
+	return pr
+}
+
+// countingReader counts the number of bytes read from an
+// io.ReadCloser.
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func countBody(body io.ReadCloser, n *int64) io.ReadCloser {
+	return &countingReader{body, n}
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// decodeReader decodes a response body. The decoders are
+// created on the first call to Read as they may read from
+// the body.
+type decodeReader struct {
+	body     io.ReadCloser
+	codings  []string
+	decoders map[string]Decoder
+
+	r       io.Reader
+	closers []io.Closer
+	err     error
+}
+
+func (d *decodeReader) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		d.err = d.init()
+	}
+
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	return d.r.Read(p)
+}
+
+func (d *decodeReader) init() error {
+	// The content codings are listed in the order they
+	// were applied, so they must be decoded in reverse.
+	r := io.Reader(d.body)
+	for i := len(d.codings) - 1; i >= 0; i-- {
+		rc, err := d.decoders[d.codings[i]](r)
+		if err != nil {
+			return err
+		}
+
+		d.closers = append(d.closers, rc)
+		r = rc
+	}
+
+	d.r = r
+	return nil
+}
+
+func (d *decodeReader) Close() error {
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		d.closers[i].Close()
+	}
+
+	return d.body.Close()
+}
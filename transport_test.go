@@ -0,0 +1,331 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return fn(r) }
+
+// newTestResponse returns an *http.Response with the given
+// Content-Encoding and body.
+func newTestResponse(req *http.Request, encoding string, body []byte) *http.Response {
+	res := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        make(http.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+
+	if encoding != "" {
+		res.Header.Set("Content-Encoding", encoding)
+	}
+
+	return res
+}
+
+func TestTransport(t *testing.T) {
+	var acceptEncoding string
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		io.WriteString(w, testBody)
+	})))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+
+	res, err := client.Get(srv.URL)
+	require.NoError(t, err, "Unexpected error making http request")
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err, "Unexpected error reading response body")
+
+	assert.Equal(t, "deflate, gzip", acceptEncoding)
+	assert.Equal(t, testBody, string(body))
+	assert.True(t, res.Uncompressed)
+	assert.Equal(t, int64(-1), res.ContentLength)
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+}
+
+func TestTransportDecoders(t *testing.T) {
+	var zlibBuf, flateBuf bytes.Buffer
+
+	zw := zlib.NewWriter(&zlibBuf)
+	io.WriteString(zw, testBody)
+	zw.Close()
+
+	fw, _ := flate.NewWriter(&flateBuf, flate.DefaultCompression)
+	io.WriteString(fw, testBody)
+	fw.Close()
+
+	rot13 := func(r io.Reader) (io.ReadCloser, error) {
+		b, err := ioutil.ReadAll(r)
+		for i, c := range b {
+			switch {
+			case c >= 'a' && c <= 'z':
+				b[i] = 'a' + (c-'a'+13)%26
+			case c >= 'A' && c <= 'Z':
+				b[i] = 'A' + (c-'A'+13)%26
+			}
+		}
+
+		return ioutil.NopCloser(bytes.NewReader(b)), err
+	}
+
+	decoders := DefaultDecoders()
+	decoders["rot13"] = rot13
+
+	for _, tc := range []struct {
+		encoding string
+		body     []byte
+		expect   string
+	}{
+		{"", []byte(testBody), testBody},
+		{"gzip", gzipStrLevel(testBody, DefaultCompression), testBody},
+		{"x-gzip", gzipStrLevel(testBody, DefaultCompression), testBody},
+		{"GZIP", gzipStrLevel(testBody, DefaultCompression), testBody},
+		{"deflate", zlibBuf.Bytes(), testBody},
+		{"deflate", flateBuf.Bytes(), testBody},
+		{"rot13", []byte("uryyb"), "hello"},
+		{"gzip, rot13", []byte("uryyb"), ""},
+		{"rot13, gzip", gzipStrLevel("uryyb", DefaultCompression), "hello"},
+		{"identity", []byte(testBody), testBody},
+	} {
+		var acceptEncoding string
+		tr := &Transport{
+			Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				acceptEncoding = req.Header.Get("Accept-Encoding")
+				return newTestResponse(req, tc.encoding, tc.body), nil
+			}),
+			Decoders: decoders,
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		res, err := tr.RoundTrip(req)
+		require.NoError(t, err, tc.encoding)
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		assert.Equal(t, "deflate, gzip, rot13", acceptEncoding, tc.encoding)
+
+		if tc.expect == "" {
+			// gzip, rot13 must be decoded as rot13 then
+			// gzip, which fails.
+			assert.Error(t, err, tc.encoding)
+			continue
+		}
+
+		require.NoError(t, err, tc.encoding)
+		assert.Equal(t, tc.expect, string(body), tc.encoding)
+
+		if tc.encoding != "identity" {
+			assert.Equal(t, "", res.Header.Get("Content-Encoding"), tc.encoding)
+		}
+	}
+}
+
+func TestTransportUnknownEncoding(t *testing.T) {
+	tr := &Transport{
+		Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return newTestResponse(req, "br", []byte("test")), nil
+		}),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	res, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.False(t, res.Uncompressed)
+	assert.Equal(t, "br", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "test", string(body))
+}
+
+func TestTransportNoDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		method string
+		header http.Header
+	}{
+		{"accept-encoding", http.MethodGet, http.Header{"Accept-Encoding": {"gzip"}}},
+		{"range", http.MethodGet, http.Header{"Range": {"bytes=0-10"}}},
+		{"head", http.MethodHead, nil},
+	} {
+		var acceptEncoding string
+		tr := &Transport{
+			Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				acceptEncoding = req.Header.Get("Accept-Encoding")
+				return newTestResponse(req, "gzip", gzipStrLevel(testBody, DefaultCompression)), nil
+			}),
+		}
+
+		req := httptest.NewRequest(tc.method, "http://example.com/", nil)
+		req.Header = tc.header
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+
+		res, err := tr.RoundTrip(req)
+		require.NoError(t, err, tc.name)
+
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err, tc.name)
+
+		assert.Equal(t, tc.header.Get("Accept-Encoding"), req.Header.Get("Accept-Encoding"), "%s: request was modified", tc.name)
+		assert.False(t, res.Uncompressed, tc.name)
+		assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"), tc.name)
+		assert.Equal(t, gzipStrLevel(testBody, DefaultCompression), body, tc.name)
+
+		if tc.method == http.MethodHead {
+			assert.Equal(t, "deflate, gzip", acceptEncoding, tc.name)
+		}
+	}
+}
+
+func TestTransportCompressRequest(t *testing.T) {
+	var (
+		encoding string
+		reqBody  []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+
+		body := io.Reader(r.Body)
+		if encoding == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			body = zr
+		}
+
+		reqBody, _ = ioutil.ReadAll(body)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{RequestMinSize: 150}}
+
+	for _, tc := range []struct {
+		body     string
+		encoding string
+	}{
+		{testBody, "gzip"},
+		{"test", ""},
+	} {
+		var stats TransportStats
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(tc.body))
+		require.NoError(t, err)
+		req = req.WithContext(WithTransportStats(req.Context(), &stats))
+
+		res, err := client.Do(req)
+		require.NoError(t, err, "Unexpected error making http request")
+		res.Body.Close()
+
+		assert.Equal(t, tc.encoding, encoding)
+		assert.Equal(t, tc.body, string(reqBody))
+
+		assert.Equal(t, int64(len(tc.body)), stats.RequestBytes)
+		if tc.encoding == "gzip" {
+			assert.True(t, stats.RequestWireBytes < stats.RequestBytes, "request body was not compressed")
+		} else {
+			assert.Equal(t, stats.RequestBytes, stats.RequestWireBytes)
+		}
+	}
+}
+
+func TestTransportCompressRequestGetBody(t *testing.T) {
+	var bodies [][]byte
+	tr := &Transport{
+		Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			for _, getBody := range []func() (io.ReadCloser, error){
+				func() (io.ReadCloser, error) { return req.Body, nil },
+				req.GetBody,
+			} {
+				body, err := getBody()
+				require.NoError(t, err)
+
+				b, err := ioutil.ReadAll(body)
+				require.NoError(t, err)
+				body.Close()
+
+				bodies = append(bodies, b)
+			}
+
+			return newTestResponse(req, "", nil), nil
+		}),
+		RequestMinSize: 1,
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(testBody))
+	require.NoError(t, err)
+
+	res, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, "", req.Header.Get("Content-Encoding"), "request was modified")
+	assert.Equal(t, int64(len(testBody)), req.ContentLength, "request was modified")
+
+	require.Len(t, bodies, 2)
+	for _, body := range bodies {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+
+		b, err := ioutil.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, testBody, string(b))
+	}
+}
+
+func TestTransportStats(t *testing.T) {
+	srv := httptest.NewServer(Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	})))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+
+	var stats TransportStats
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req = req.WithContext(WithTransportStats(req.Context(), &stats))
+
+	res, err := client.Do(req)
+	require.NoError(t, err, "Unexpected error making http request")
+
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, TransportStats{
+		ResponseBytes:     int64(len(testBody)),
+		ResponseWireBytes: int64(len(gzipStrLevel(testBody, DefaultCompression))),
+	}, stats)
+}
+
+func TestTransportInvalidLevel(t *testing.T) {
+	tr := &Transport{RequestCompressionLevel: BestCompression + 1}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	_, err := tr.RoundTrip(req)
+	assert.Equal(t, errInvalidRequestLevel, err)
+}
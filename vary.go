@@ -0,0 +1,68 @@
+package gziphandler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AddVary adds the given header field names to the Vary
+// header of h, merging them with any that are already
+// present.
+//
+// Field names are compared in a case-insensitive manner
+// and are only added if not already present. Multiple
+// Vary header lines are merged into a single
+// comma-separated line.
+//
+// If the Vary header contains *, or * is given as a field
+// name, the Vary header will be set to just * as the
+// response already varies on more than the request
+// headers.
+//
+// AddVary is used by the gzip handler to add
+// Accept-Encoding to the Vary header. It is provided for
+// use by other middlewares that need to do likewise.
+func AddVary(h http.Header, fields ...string) {
+	lines := h["Vary"]
+
+	var (
+		merged []string
+		star   bool
+	)
+	add := func(field string) {
+		switch {
+		case field == "":
+		case field == "*":
+			star = true
+		case !containsFold(merged, field):
+			merged = append(merged, field)
+		}
+	}
+
+	for _, line := range lines {
+		for _, field := range strings.Split(line, ",") {
+			add(strings.TrimSpace(field))
+		}
+	}
+
+	for _, field := range fields {
+		add(strings.TrimSpace(field))
+	}
+
+	switch {
+	case star:
+		h.Set("Vary", "*")
+	case len(merged) != 0:
+		h.Set("Vary", strings.Join(merged, ", "))
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,36 @@
+package gziphandler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddVary(t *testing.T) {
+	for _, tc := range []struct {
+		vary   []string
+		fields []string
+		expect []string
+	}{
+		{nil, nil, nil},
+		{nil, []string{"Accept-Encoding"}, []string{"Accept-Encoding"}},
+		{nil, []string{"Accept-Encoding", "User-Agent"}, []string{"Accept-Encoding, User-Agent"}},
+		{nil, []string{"Accept-Encoding", "accept-encoding"}, []string{"Accept-Encoding"}},
+		{[]string{"Origin"}, []string{"Accept-Encoding"}, []string{"Origin, Accept-Encoding"}},
+		{[]string{"Origin, Accept-Encoding"}, []string{"Accept-Encoding"}, []string{"Origin, Accept-Encoding"}},
+		{[]string{"Origin", "ACCEPT-ENCODING"}, []string{"Accept-Encoding"}, []string{"Origin, ACCEPT-ENCODING"}},
+		{[]string{" Origin ,, Cookie "}, nil, []string{"Origin, Cookie"}},
+		{[]string{"*"}, []string{"Accept-Encoding"}, []string{"*"}},
+		{[]string{"Origin", "*"}, []string{"Accept-Encoding"}, []string{"*"}},
+		{[]string{"Origin"}, []string{"*"}, []string{"*"}},
+	} {
+		h := make(http.Header)
+		if tc.vary != nil {
+			h["Vary"] = append([]string(nil), tc.vary...)
+		}
+
+		AddVary(h, tc.fields...)
+		assert.Equal(t, tc.expect, h["Vary"], "%+v", tc)
+	}
+}
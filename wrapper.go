@@ -0,0 +1,129 @@
+// Code generated by wrapper_gen.go. DO NOT EDIT.
+
+package gziphandler
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// These constants identify the optional interfaces that
+// an http.ResponseWriter may implement.
+const (
+	closeNotifyType = 1 << iota
+	hijackType
+	pusherType
+)
+
+// wrapResponseWriter returns an http.ResponseWriter that
+// wraps w and implements the same optional interfaces as
+// w.ResponseWriter.
+func wrapResponseWriter(w *responseWriter) http.ResponseWriter {
+	var mask int
+	if _, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		mask |= closeNotifyType
+	}
+	if _, ok := w.ResponseWriter.(http.Hijacker); ok {
+		mask |= hijackType
+	}
+	if _, ok := w.ResponseWriter.(http.Pusher); ok {
+		mask |= pusherType
+	}
+
+	switch mask {
+	case 0:
+		return w
+	case closeNotifyType:
+		return closeNotifyResponseWriter{w}
+	case hijackType:
+		return hijackResponseWriter{w}
+	case closeNotifyType | hijackType:
+		return closeNotifyHijackResponseWriter{w}
+	case pusherType:
+		return pusherResponseWriter{w}
+	case closeNotifyType | pusherType:
+		return closeNotifyPusherResponseWriter{w}
+	case hijackType | pusherType:
+		return hijackPusherResponseWriter{w}
+	case closeNotifyType | hijackType | pusherType:
+		return closeNotifyHijackPusherResponseWriter{w}
+	default:
+		panic("gziphandler: unreachable")
+	}
+}
+
+type (
+	// Each of these structs is intentionally small (1 pointer wide) so
+	// as to fit inside an interface{} without causing an allocaction.
+	closeNotifyResponseWriter             struct{ *responseWriter }
+	hijackResponseWriter                  struct{ *responseWriter }
+	closeNotifyHijackResponseWriter       struct{ *responseWriter }
+	pusherResponseWriter                  struct{ *responseWriter }
+	closeNotifyPusherResponseWriter       struct{ *responseWriter }
+	hijackPusherResponseWriter            struct{ *responseWriter }
+	closeNotifyHijackPusherResponseWriter struct{ *responseWriter }
+)
+
+var (
+	_ http.CloseNotifier = closeNotifyResponseWriter{}
+	_ http.Hijacker      = hijackResponseWriter{}
+	_ http.CloseNotifier = closeNotifyHijackResponseWriter{}
+	_ http.Hijacker      = closeNotifyHijackResponseWriter{}
+	_ http.Pusher        = pusherResponseWriter{}
+	_ http.CloseNotifier = closeNotifyPusherResponseWriter{}
+	_ http.Pusher        = closeNotifyPusherResponseWriter{}
+	_ http.Hijacker      = hijackPusherResponseWriter{}
+	_ http.Pusher        = hijackPusherResponseWriter{}
+	_ http.CloseNotifier = closeNotifyHijackPusherResponseWriter{}
+	_ http.Hijacker      = closeNotifyHijackPusherResponseWriter{}
+	_ http.Pusher        = closeNotifyHijackPusherResponseWriter{}
+)
+
+func (w closeNotifyResponseWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w closeNotifyHijackResponseWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w closeNotifyHijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w pusherResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+func (w closeNotifyPusherResponseWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w closeNotifyPusherResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+func (w hijackPusherResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w hijackPusherResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+func (w closeNotifyHijackPusherResponseWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w closeNotifyHijackPusherResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w closeNotifyHijackPusherResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
@@ -0,0 +1,264 @@
+//go:build ignore
+// +build ignore
+
+// This program generates wrapper.go and wrapper_test.go.
+// Invoke it as:
+//
+//	go run wrapper_gen.go
+//
+// or with go generate.
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// iface is an optional interface that an
+// http.ResponseWriter may implement and that the wrapped
+// http.ResponseWriter passed to the handler must preserve.
+type iface struct {
+	// Name is used to build the type and constant names.
+	Name string
+
+	// Type is the qualified name of the interface.
+	Type string
+
+	// Arg is the argument name used in wrapper_test.go.
+	Arg string
+
+	// Method is the method signature.
+	Method string
+
+	// Call is the body of the method.
+	Call string
+
+	// Imports are the packages used by Method, other
+	// than net/http.
+	Imports []string
+}
+
+var ifaces = []iface{
+	{
+		Name:   "closeNotify",
+		Type:   "http.CloseNotifier",
+		Arg:    "c",
+		Method: "CloseNotify() <-chan bool",
+		Call:   "w.ResponseWriter.(http.CloseNotifier).CloseNotify()",
+	},
+	{
+		Name:   "hijack",
+		Type:   "http.Hijacker",
+		Arg:    "h",
+		Method: "Hijack() (net.Conn, *bufio.ReadWriter, error)",
+		Call:   "w.hijack()",
+
+		Imports: []string{"bufio", "net"},
+	},
+	{
+		Name:   "pusher",
+		Type:   "http.Pusher",
+		Arg:    "p",
+		Method: "Push(target string, opts *http.PushOptions) error",
+		Call:   "w.ResponseWriter.(http.Pusher).Push(target, opts)",
+	},
+}
+
+// combination is a set of optional interfaces.
+type combination struct {
+	Mask   int
+	Ifaces []iface
+}
+
+func (c combination) TypeName() string {
+	if len(c.Ifaces) == 0 {
+		return "responseWriter"
+	}
+
+	var name strings.Builder
+	for i, iface := range c.Ifaces {
+		if i == 0 {
+			name.WriteString(iface.Name)
+		} else {
+			name.WriteString(strings.ToUpper(iface.Name[:1]) + iface.Name[1:])
+		}
+	}
+
+	name.WriteString("ResponseWriter")
+	return name.String()
+}
+
+func (c combination) MaskExpr() string {
+	if len(c.Ifaces) == 0 {
+		return "0"
+	}
+
+	names := make([]string, len(c.Ifaces))
+	for i, iface := range c.Ifaces {
+		names[i] = iface.Name + "Type"
+	}
+
+	return strings.Join(names, " | ")
+}
+
+func combinations() []combination {
+	combs := make([]combination, 1<<uint(len(ifaces)))
+	for mask := range combs {
+		combs[mask].Mask = mask
+
+		for i, iface := range ifaces {
+			if mask&(1<<uint(i)) != 0 {
+				combs[mask].Ifaces = append(combs[mask].Ifaces, iface)
+			}
+		}
+	}
+
+	return combs
+}
+
+var wrapperTmpl = template.Must(template.New("wrapper").Parse(`// Code generated by wrapper_gen.go. DO NOT EDIT.
+
+package gziphandler
+
+import (
+{{- range .Imports}}
+	"{{.}}"
+{{- end}}
+)
+
+// These constants identify the optional interfaces that
+// an http.ResponseWriter may implement.
+const (
+{{- range $i, $iface := .Ifaces}}
+	{{$iface.Name}}Type{{if eq $i 0}} = 1 << iota{{end}}
+{{- end}}
+)
+
+// wrapResponseWriter returns an http.ResponseWriter that
+// wraps w and implements the same optional interfaces as
+// w.ResponseWriter.
+func wrapResponseWriter(w *responseWriter) http.ResponseWriter {
+	var mask int
+{{- range .Ifaces}}
+	if _, ok := w.ResponseWriter.({{.Type}}); ok {
+		mask |= {{.Name}}Type
+	}
+{{- end}}
+
+	switch mask {
+{{- range .Combinations}}
+	case {{.MaskExpr}}:
+{{- if .Ifaces}}
+		return {{.TypeName}}{w}
+{{- else}}
+		return w
+{{- end}}
+{{- end}}
+	default:
+		panic("gziphandler: unreachable")
+	}
+}
+
+type (
+	// Each of these structs is intentionally small (1 pointer wide) so
+	// as to fit inside an interface{} without causing an allocaction.
+{{- range .Combinations}}{{if .Ifaces}}
+	{{.TypeName}} struct{ *responseWriter }
+{{- end}}{{end}}
+)
+
+var (
+{{- range .Combinations}}{{$c := .}}{{range .Ifaces}}
+	_ {{.Type}} = {{$c.TypeName}}{}
+{{- end}}{{end}}
+)
+{{range .Combinations}}{{$c := .}}{{range .Ifaces}}
+func (w {{$c.TypeName}}) {{.Method}} {
+	return {{.Call}}
+}
+{{end}}{{end}}`))
+
+var testTmpl = template.Must(template.New("wrapper_test").Parse(`// Code generated by wrapper_gen.go. DO NOT EDIT.
+
+package gziphandler
+
+import "net/http"
+
+// responseWriterTypesMask has a bit set for each of the
+// optional interfaces that an http.ResponseWriter may
+// implement.
+const responseWriterTypesMask = {{range $i, $iface := .Ifaces}}{{if $i}} | {{end}}{{$iface.Name}}Type{{end}}
+
+// newTestResponseWriter returns an http.ResponseWriter
+// that implements only the optional interfaces in mask.
+// Each interface is implemented by the matching argument.
+func newTestResponseWriter(mask int, w http.ResponseWriter{{range .Ifaces}}, {{.Arg}} {{.Type}}{{end}}) http.ResponseWriter {
+	switch mask {
+{{- range .Combinations}}
+	case {{.MaskExpr}}:
+		return struct {
+			http.ResponseWriter
+{{- range .Ifaces}}
+			{{.Type}}
+{{- end}}
+		}{w{{range .Ifaces}}, {{.Arg}}{{end}}}
+{{- end}}
+	default:
+		panic("gziphandler: invalid mask")
+	}
+}
+`))
+
+func imports() []string {
+	imports := []string{"net/http"}
+	for _, iface := range ifaces {
+		for _, imp := range iface.Imports {
+			if !contains(imports, imp) {
+				imports = append(imports, imp)
+			}
+		}
+	}
+
+	sort.Strings(imports)
+	return imports
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func generate(tmpl *template.Template, filename string) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct {
+		Ifaces       []iface
+		Combinations []combination
+		Imports      []string
+	}{ifaces, combinations(), imports()}); err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("%s: %v\n%s", filename, err, buf.Bytes())
+	}
+
+	if err := ioutil.WriteFile(filename, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	generate(wrapperTmpl, "wrapper.go")
+	generate(testTmpl, "wrapper_test.go")
+}
@@ -0,0 +1,82 @@
+package gziphandler
+
+import "sync"
+
+var writeBufferPool = &sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+func writeBufferGet(size int) *[]byte {
+	wb := writeBufferPool.Get().(*[]byte)
+	if cap(*wb) < size {
+		*wb = make([]byte, 0, size)
+	}
+
+	*wb = (*wb)[:0]
+	return wb
+}
+
+// encode writes b to the gzip writer. If there is a write
+// buffer, small writes are coalesced in it and an error
+// from an earlier write may be returned instead. See
+// WriteBufferSize.
+func (w *responseWriter) encode(b []byte) (int, error) {
+	if w.wb == nil {
+		start := w.now()
+		n, err := w.encoder().Write(b)
+		w.addEncodeTime(start)
+		return n, err
+	}
+
+	// The error has already been recorded.
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	wb := *w.wb
+	if len(wb)+len(b) <= cap(wb) {
+		*w.wb = append(wb, b...)
+		return len(b), nil
+	}
+
+	if err := w.flushWriteBuffer(); err != nil {
+		return 0, err
+	}
+
+	// Writes at least as large as the buffer gain nothing
+	// from being copied into it.
+	if len(b) >= cap(wb) {
+		start := w.now()
+		n, err := w.encoder().Write(b)
+		w.addEncodeTime(start)
+		return n, err
+	}
+
+	*w.wb = append((*w.wb)[:0], b...)
+	return len(b), nil
+}
+
+// flushWriteBuffer writes the contents of the write buffer
+// to the gzip writer.
+func (w *responseWriter) flushWriteBuffer() error {
+	if w.wb == nil || len(*w.wb) == 0 {
+		return nil
+	}
+
+	start := w.now()
+	_, err := w.encoder().Write(*w.wb)
+	w.addEncodeTime(start)
+
+	*w.wb = (*w.wb)[:0]
+	return err
+}
+
+// releaseWriteBuffer returns the write buffer to the pool.
+func (w *responseWriter) releaseWriteBuffer() {
+	if w.wb != nil {
+		writeBufferPool.Put(w.wb)
+		w.wb = nil
+	}
+}
@@ -0,0 +1,141 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBufferSize(t *testing.T) {
+	for _, size := range []int{0, 16, 100, 4096} {
+		for _, writeString := range []bool{false, true} {
+			handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Write in fragments of varying size,
+				// some larger than the buffer.
+				for body, n := testBody, 1; len(body) != 0; n = n%150 + 7 {
+					if n > len(body) {
+						n = len(body)
+					}
+
+					if writeString {
+						io.WriteString(w, body[:n])
+					} else {
+						w.Write([]byte(body[:n]))
+					}
+
+					body = body[n:]
+				}
+			}), WriteBufferSize(size))
+
+			req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			// The output of compress/flate doesn't depend
+			// on how the input is split.
+			assert.Equal(t, gzipStrLevel(testBody, DefaultCompression), resp.Body.Bytes(),
+				"size %d, WriteString %t", size, writeString)
+		}
+	}
+}
+
+func TestWriteBufferSizeFlush(t *testing.T) {
+	var flushed []byte
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, testBody)
+		io.WriteString(w, "flushed")
+		w.(http.Flusher).Flush()
+
+		// Everything written so far must have been
+		// compressed and written.
+		rec := w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder)
+		flushed = append(flushed, rec.Body.Bytes()...)
+
+		io.WriteString(w, "closed")
+	}), WriteBufferSize(4096))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	zr, err := gzip.NewReader(bytes.NewReader(flushed))
+	require.NoError(t, err)
+
+	got, err := ioutil.ReadAll(zr)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, testBody+"flushed", string(got))
+
+	zr, err = gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	got, err = ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, testBody+"flushedclosed", string(got))
+}
+
+func TestWriteBufferSizeStreaming(t *testing.T) {
+	var flushes []int
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+
+		rec := w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder)
+		for i := 0; i < 3; i++ {
+			io.WriteString(w, "data: ")
+			io.WriteString(w, "test\n\n")
+			flushes = append(flushes, rec.Body.Len())
+		}
+	}), WriteBufferSize(4096))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	// Each event must be written as soon as it ends.
+	require.Len(t, flushes, 3)
+	assert.True(t, flushes[0] > 0 && flushes[0] < flushes[1] && flushes[1] < flushes[2],
+		"events were not flushed: %v", flushes)
+}
+
+func TestWriteBufferSizeError(t *testing.T) {
+	var errs []error
+	handler := Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for i := 0; i < 10; i++ {
+			io.WriteString(w, testBody)
+		}
+	}), WriteBufferSize(4096), ErrorHandler(func(r *http.Request, err error) {
+		errs = append(errs, err)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	handler.ServeHTTP(&errorResponseWriter{ResponseRecorder: httptest.NewRecorder()}, req)
+
+	require.Len(t, errs, 1)
+
+	var we *WriteError
+	assert.True(t, errors.As(errs[0], &we), "expected *WriteError, got %#v", errs[0])
+}
+
+func TestWriteBufferSizePanicsForInvalid(t *testing.T) {
+	assert.Panics(t, func() {
+		WriteBufferSize(-1)
+	})
+}